@@ -0,0 +1,190 @@
+package sshost
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AddKeysToAgent specifies if private keys loaded from an IdentityFile are added to the agent at IdentityAgent.
+type AddKeysToAgent struct {
+	Mode AddKeysMode
+
+	// Lifetime is the maximum time keys are held by the agent.
+	// Zero means that keys are held until they are removed.
+	Lifetime time.Duration
+}
+
+// AddKeysMode specifies how keys are added to the agent
+type AddKeysMode string
+
+const (
+	DefaultAddKeysMode AddKeysMode = "no"
+	AddKeysYes         AddKeysMode = "yes"
+	AddKeysAsk         AddKeysMode = "ask"     // ask the user before adding keys
+	AddKeysConfirm     AddKeysMode = "confirm" // the agent asks the user before each use of the key
+)
+
+// Valid checks if the provided AddKeysMode is valid
+func (mode AddKeysMode) Valid() bool {
+	return mode == DefaultAddKeysMode || mode == AddKeysYes || mode == AddKeysAsk || mode == AddKeysConfirm
+}
+
+// parseAddKeysToAgent parses the value of the AddKeysToAgent setting.
+// It consists of a mode, a lifetime in the format of parseTimeFormat, or a mode followed by a lifetime.
+// A lifetime without a mode implies AddKeysYes.
+func parseAddKeysToAgent(value string) (AddKeysToAgent, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return AddKeysToAgent{}, ErrNotATime
+	}
+
+	if len(fields) == 1 {
+		if lifetime, err := parseTimeFormat(fields[0]); err == nil {
+			return AddKeysToAgent{Mode: AddKeysYes, Lifetime: lifetime}, nil
+		}
+		return AddKeysToAgent{Mode: AddKeysMode(strings.ToLower(fields[0]))}, nil
+	}
+
+	lifetime, err := parseTimeFormat(fields[1])
+	if err != nil {
+		return AddKeysToAgent{}, err
+	}
+	return AddKeysToAgent{Mode: AddKeysMode(strings.ToLower(fields[0])), Lifetime: lifetime}, nil
+}
+
+// loadedKey is a private key loaded from an IdentityFile during authentication
+type loadedKey struct {
+	path   string
+	key    interface{}
+	public ssh.PublicKey
+}
+
+// recordLoadedKey records that the private key at path was loaded during authentication.
+// Recorded keys are added to the agent by addKeysToAgent once the connection has been established.
+func (profile *Profile) recordLoadedKey(path string, key interface{}, public ssh.PublicKey) {
+	profile.authM.Lock()
+	defer profile.authM.Unlock()
+
+	for _, loaded := range profile.loaded {
+		if loaded.path == path {
+			return
+		}
+	}
+	profile.loaded = append(profile.loaded, loadedKey{path: path, key: key, public: public})
+}
+
+// loadedSigner is a signer for a private key loaded from an IdentityFile.
+// The key is recorded by recordLoadedKey once a signature is made, that is once the server accepted the key.
+type loadedSigner struct {
+	ssh.MultiAlgorithmSigner
+	record func()
+}
+
+// newLoadedSigner wraps signer for the private key loaded from path, see loadedSigner.
+// Signers that do not support multiple algorithms are returned unchanged, and their key is never recorded.
+func (profile *Profile) newLoadedSigner(path string, key interface{}, signer ssh.Signer) ssh.Signer {
+	multi, ok := signer.(ssh.MultiAlgorithmSigner)
+	if !ok {
+		return signer
+	}
+	return loadedSigner{
+		MultiAlgorithmSigner: multi,
+		record:               func() { profile.recordLoadedKey(path, key, multi.PublicKey()) },
+	}
+}
+
+func (signer loadedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signature, err := signer.MultiAlgorithmSigner.Sign(rand, data)
+	if err == nil {
+		signer.record()
+	}
+	return signature, err
+}
+
+func (signer loadedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signature, err := signer.MultiAlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+	if err == nil {
+		signer.record()
+	}
+	return signature, err
+}
+
+// addKeysToAgent adds the keys recorded by recordLoadedKey to the agent, as configured by AddKeysToAgent.
+// Keys already held by the agent are skipped.
+// Recorded keys are forgotten afterwards, and failures to add them are ignored.
+func (m AuthEnv) addKeysToAgent(profile *Profile) {
+	profile.authM.Lock()
+	loaded := profile.loaded
+	profile.loaded = nil
+	profile.authM.Unlock()
+
+	cfg := profile.config.AddKeysToAgent
+	if len(loaded) == 0 || cfg.Mode == DefaultAddKeysMode || cfg.Mode == "" {
+		return
+	}
+
+	socket := profile.IdentityAgent()
+	if socket == "" {
+		return
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	client := agent.NewClient(conn)
+	held, err := client.List()
+	if err != nil {
+		return
+	}
+
+	for _, key := range loaded {
+		if agentHolds(held, key.public) {
+			continue
+		}
+		if cfg.Mode == AddKeysAsk {
+			ok, err := m.askAddKey(key.path)
+			if err != nil || !ok {
+				continue
+			}
+		}
+
+		client.Add(agent.AddedKey{
+			PrivateKey:       key.key,
+			Comment:          key.path,
+			LifetimeSecs:     uint32(cfg.Lifetime / time.Second),
+			ConfirmBeforeUse: cfg.Mode == AddKeysConfirm,
+		})
+	}
+}
+
+// agentHolds checks if public is one of the keys held by an agent
+func agentHolds(held []*agent.Key, public ssh.PublicKey) bool {
+	blob := public.Marshal()
+	for _, key := range held {
+		if bytes.Equal(key.Blob, blob) {
+			return true
+		}
+	}
+	return false
+}
+
+// askAddKey asks the user if the key loaded from path may be added to the agent
+func (m AuthEnv) askAddKey(path string) (bool, error) {
+	m.print(fmt.Sprintf("Add key %s to the agent? ", path), false)
+
+	answer, err := m.readOpen()
+	if err != nil {
+		return false, err
+	}
+	return strings.ToLower(answer) == "yes", nil
+}
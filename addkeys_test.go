@@ -0,0 +1,168 @@
+//go:build unix
+
+package sshost
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// countingAgent is an agent that counts calls to Add
+type countingAgent struct {
+	agent.Agent
+	adds atomic.Int32
+}
+
+func (c *countingAgent) Add(key agent.AddedKey) error {
+	c.adds.Add(1)
+	return c.Agent.Add(key)
+}
+
+// startAgent serves a new keyring on a unix socket, and returns the path to the socket
+func startAgent(t *testing.T) (*countingAgent, string) {
+	t.Helper()
+
+	keyring := &countingAgent{Agent: agent.NewKeyring()}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return keyring, socket
+}
+
+func TestAuthEnv_addKeysToAgent(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		mode      AddKeysMode
+		input     string
+		wantAdds  int32
+		wantAsked bool
+	}{
+		{"no", DefaultAddKeysMode, "", 0, false},
+		{"yes", AddKeysYes, "", 1, false},
+		{"confirm", AddKeysConfirm, "", 1, false},
+		{"ask accepted", AddKeysAsk, "yes\n", 1, true},
+		{"ask declined", AddKeysAsk, "no\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, socket := startAgent(t)
+
+			var out strings.Builder
+			auth := AuthEnv{Stdin: strings.NewReader(tt.input), Stdout: &out}
+			profile := &Profile{
+				env: &Environment{Auth: auth},
+				config: Config{
+					IdentityAgent:  socket,
+					AddKeysToAgent: AddKeysToAgent{Mode: tt.mode},
+				},
+			}
+
+			// keys are only recorded during authentication, and may be loaded more than once
+			profile.recordLoadedKey("/home/user/.ssh/id_ed25519", key, signer.PublicKey())
+			profile.recordLoadedKey("/home/user/.ssh/id_ed25519", key, signer.PublicKey())
+			if got := keyring.adds.Load(); got != 0 {
+				t.Fatalf("recordLoadedKey() added %d keys", got)
+			}
+
+			auth.addKeysToAgent(profile)
+			if got := keyring.adds.Load(); got != tt.wantAdds {
+				t.Errorf("addKeysToAgent() added %d keys, want %d", got, tt.wantAdds)
+			}
+			if asked := out.Len() > 0; asked != tt.wantAsked {
+				t.Errorf("addKeysToAgent() asked = %v, want %v", asked, tt.wantAsked)
+			}
+
+			// a second connection neither adds nor asks about keys already in the agent
+			out.Reset()
+			profile.recordLoadedKey("/home/user/.ssh/id_ed25519", key, signer.PublicKey())
+			auth.addKeysToAgent(profile)
+			if got := keyring.adds.Load(); got != tt.wantAdds {
+				t.Errorf("second addKeysToAgent() added %d keys, want %d", got-tt.wantAdds, 0)
+			}
+			if tt.wantAdds > 0 && out.Len() > 0 {
+				t.Errorf("second addKeysToAgent() asked about a key in the agent")
+			}
+		})
+	}
+}
+
+func TestProfile_newLoadedSigner(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{Key: signer.PublicKey(), CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		wrap func(ssh.Signer) (ssh.Signer, error)
+	}{
+		{"key", func(s ssh.Signer) (ssh.Signer, error) { return s, nil }},
+		{"certificate", func(s ssh.Signer) (ssh.Signer, error) { return ssh.NewCertSigner(cert, s) }},
+		{"restricted", func(s ssh.Signer) (ssh.Signer, error) {
+			return ssh.NewSignerWithAlgorithms(s.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoED25519})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := &Profile{env: &Environment{}}
+
+			loaded, err := tt.wrap(profile.newLoadedSigner("/home/user/.ssh/id_ed25519", key, signer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(profile.loaded) != 0 {
+				t.Fatalf("newLoadedSigner() recorded the key before it was used")
+			}
+
+			if _, err := loaded.Sign(rand.Reader, []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+			if len(profile.loaded) != 1 {
+				t.Errorf("Sign() recorded %d keys, want 1", len(profile.loaded))
+			}
+		})
+	}
+}
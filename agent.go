@@ -0,0 +1,128 @@
+package sshost
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var forwardAgentFlags = expand.Flags{
+	Environment: true,
+	Tilde:       true,
+	Tokens:      "%CdhijkLlnpru",
+}
+
+// ForwardAgentSocket returns the socket of the agent to forward to the remote host.
+// When agent forwarding is disabled, returns the empty string.
+//
+// When ForwardAgent is "yes", the socket given by IdentityAgent is forwarded.
+// When IdentityAgent is "none", it only disables the agent for authentication, and the socket in SSH_AUTH_SOCK is forwarded instead.
+// Otherwise ForwardAgent is an environment variable of the form "$VAR", or the path to the socket.
+// "yes" and "no" are matched case-insensitively.
+func (profile *Profile) ForwardAgentSocket() string {
+	value := profile.config.ForwardAgent
+
+	switch strings.ToLower(value) {
+	case "", "no":
+		return ""
+	case "yes":
+		if socket := profile.IdentityAgent(); socket != "" {
+			return socket
+		}
+		return profile.env.getenv("SSH_AUTH_SOCK")
+	}
+	if value[0] == '$' {
+		return profile.env.getenv(value[1:])
+	}
+
+	ex := profile.expander()
+	value, _ = ex.Expand(value, forwardAgentFlags)
+	return value
+}
+
+// validForwardAgent checks if value is a valid ForwardAgent value other than "yes" and "no".
+// It must be an environment variable of the form "$VAR", or an absolute path that may start with '~' or a token.
+func validForwardAgent(value string) bool {
+	if value == "" {
+		return false
+	}
+	if value[0] == '$' {
+		return validVariableName(value[1:])
+	}
+	return value[0] == '/' || value[0] == '~' || value[0] == '%'
+}
+
+// validVariableName checks if name is a valid name of an environment variable
+func validVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ErrNoForwardAgent is returned when agent forwarding is requested, but there is no agent to forward
+var ErrNoForwardAgent = errors.New("no agent to forward")
+
+// ForwardAgent forwards an agent to the remote host, by handling agent channels opened by the server.
+// It must be called at most once for each client.
+//
+// When keyring is non-nil, keyring is forwarded instead of the agent at ForwardAgentSocket.
+// Use agent.NewKeyring to forward only a restricted set of keys.
+//
+// Agent forwarding must still be requested for each session, see NewSession.
+func (profile *Profile) ForwardAgent(client *ssh.Client, keyring agent.Agent) error {
+	if keyring != nil {
+		return agent.ForwardToAgent(client, keyring)
+	}
+
+	socket := profile.ForwardAgentSocket()
+	if socket == "" {
+		return ErrNoForwardAgent
+	}
+	return agent.ForwardToRemote(client, socket)
+}
+
+// forwardAgent calls ForwardAgent with the keyring of the environment, when agent forwarding is enabled
+func (profile *Profile) forwardAgent(client *ssh.Client) error {
+	if !profile.agentForwarding() {
+		return nil
+	}
+	return profile.ForwardAgent(client, profile.env.ForwardKeyring)
+}
+
+// agentForwarding checks if agent forwarding is enabled for this profile, and there is an agent to forward
+func (profile *Profile) agentForwarding() bool {
+	switch strings.ToLower(profile.config.ForwardAgent) {
+	case "", "no":
+		return false
+	}
+	return profile.env.ForwardKeyring != nil || profile.ForwardAgentSocket() != ""
+}
+
+// NewSession opens a new session using client.
+// When ForwardAgent is enabled and there is an agent to forward, agent forwarding is requested for the session.
+func (profile *Profile) NewSession(client *ssh.Client) (*ssh.Session, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.agentForwarding() {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+	return session, nil
+}
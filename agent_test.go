@@ -0,0 +1,82 @@
+package sshost
+
+import "testing"
+
+func TestProfile_ForwardAgentSocket(t *testing.T) {
+	variables := map[string]string{
+		"HOME":          "/home/user",
+		"SSH_AUTH_SOCK": "/tmp/ssh-agent.sock",
+		"OTHER_SOCK":    "/tmp/other.sock",
+	}
+
+	tests := []struct {
+		name          string
+		forwardAgent  string
+		identityAgent string
+		want          string
+	}{
+		{"unset", "", "SSH_AUTH_SOCK", ""},
+		{"no", "no", "SSH_AUTH_SOCK", ""},
+		{"yes", "yes", "SSH_AUTH_SOCK", "/tmp/ssh-agent.sock"},
+		{"yes uppercase", "Yes", "SSH_AUTH_SOCK", "/tmp/ssh-agent.sock"},
+		{"no uppercase", "NO", "SSH_AUTH_SOCK", ""},
+		{"yes with identity agent", "yes", "~/agent.sock", "/home/user/agent.sock"},
+		{"yes with identity agent none", "yes", "none", "/tmp/ssh-agent.sock"},
+		{"variable", "$OTHER_SOCK", "none", "/tmp/other.sock"},
+		{"path", "~/forward-%r.sock", "none", "/home/user/forward-user.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := &Profile{
+				env: &Environment{
+					Local:     Local{Home: "/home/user"},
+					Variables: func(name string) string { return variables[name] },
+				},
+				config: Config{
+					Hostname:      "example.com",
+					Port:          22,
+					Username:      "user",
+					ForwardAgent:  tt.forwardAgent,
+					IdentityAgent: tt.identityAgent,
+				},
+			}
+			if got := profile.ForwardAgentSocket(); got != tt.want {
+				t.Errorf("Profile.ForwardAgentSocket() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_forwardAgent(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"yes", "yes", false},
+		{"Yes", "yes", false},
+		{"NO", "no", false},
+		{"$SSH_AUTH_SOCK", "$SSH_AUTH_SOCK", false},
+		{"/tmp/agent.sock", "/tmp/agent.sock", false},
+		{"~/agent.sock", "~/agent.sock", false},
+		{"%d/agent.sock", "%d/agent.sock", false},
+		{"$", "", true},
+		{"$1SOCK", "", true},
+		{"agent.sock", "", true},
+		{"maybe", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cfg := newTestConfig(t, "")
+			cfg.ForwardAgent = tt.value
+
+			err := cfg.Validate(true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.ForwardAgent != tt.want {
+				t.Errorf("Config.ForwardAgent = %q, want %q", cfg.ForwardAgent, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package sshost
+
+import (
+	"strings"
+
+	"github.com/tkw1536/sshost/internal/pkg/pattern"
+	"github.com/tkw1536/sshost/internal/pkg/slices"
+)
+
+// applyAlgorithms applies the modifier of an algorithm list to defaults, see ssh_config(5).
+//
+// When the first element of spec starts with '+', algorithms in known matching any of the patterns are appended to defaults.
+// When it starts with '-', algorithms matching any of the patterns are removed from defaults.
+// When it starts with '^', algorithms in known matching any of the patterns are placed at the front of defaults.
+// Otherwise, spec is a plain list: each element containing a wildcard is replaced by the algorithms in known matching it,
+// and all other elements are kept unchanged.
+//
+// When the result is empty, returns slices.ErrNoValue.
+// The returned slice never shares memory with defaults.
+func applyAlgorithms(spec []string, defaults []string, known []string) ([]string, error) {
+	if len(spec) == 0 || spec[0] == "" {
+		return spec, nil
+	}
+
+	modifier := spec[0][0]
+	patterns := append([]string{spec[0][1:]}, spec[1:]...)
+
+	var result []string
+	switch modifier {
+	case '+':
+		result = slices.Combine(defaults)
+		for _, algo := range known {
+			if pattern.MatchAny(patterns, algo, false) && !slices.Contains(result, algo) {
+				result = append(result, algo)
+			}
+		}
+	case '-':
+		for _, algo := range defaults {
+			if !pattern.MatchAny(patterns, algo, false) {
+				result = append(result, algo)
+			}
+		}
+	case '^':
+		for _, algo := range known {
+			if pattern.MatchAny(patterns, algo, false) {
+				result = append(result, algo)
+			}
+		}
+		for _, algo := range defaults {
+			if !slices.Contains(result, algo) {
+				result = append(result, algo)
+			}
+		}
+	default:
+		for _, element := range spec {
+			if !strings.ContainsAny(element, "*?") {
+				if !slices.Contains(result, element) {
+					result = append(result, element)
+				}
+				continue
+			}
+			for _, algo := range known {
+				if pattern.Match(element, algo) && !slices.Contains(result, algo) {
+					result = append(result, algo)
+				}
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, slices.ErrNoValue
+	}
+	return result, nil
+}
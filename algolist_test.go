@@ -0,0 +1,162 @@
+package sshost
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tkw1536/sshost/internal/pkg/slices"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_applyAlgorithms(t *testing.T) {
+	defaults := []string{"curve25519-sha256", "ecdh-sha2-nistp256"}
+	known := []string{"curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "diffie-hellman-group14-sha1"}
+
+	tests := []struct {
+		name    string
+		spec    []string
+		want    []string
+		wantErr error
+	}{
+		{"unset", nil, nil, nil},
+		{"empty", []string{""}, []string{""}, nil},
+
+		{"plain", []string{"ecdh-sha2-nistp384", "curve25519-sha256"}, []string{"ecdh-sha2-nistp384", "curve25519-sha256"}, nil},
+		{"plain unknown", []string{"unknown-algorithm"}, []string{"unknown-algorithm"}, nil},
+		{"plain wildcard", []string{"curve25519*"}, []string{"curve25519-sha256", "curve25519-sha256@libssh.org"}, nil},
+		{"plain wildcard keeps order", []string{"ecdh-sha2-nistp384", "curve25519*", "ecdh-*"}, []string{"ecdh-sha2-nistp384", "curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256"}, nil},
+		{"plain single character wildcard", []string{"ecdh-sha2-nistp?84"}, []string{"ecdh-sha2-nistp384"}, nil},
+		{"plain wildcard without match", []string{"sntrup*"}, nil, slices.ErrNoValue},
+
+		{"append", []string{"+diffie-hellman-group14-sha1"}, []string{"curve25519-sha256", "ecdh-sha2-nistp256", "diffie-hellman-group14-sha1"}, nil},
+		{"append wildcard", []string{"+ecdh-*", "diffie-*"}, []string{"curve25519-sha256", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "diffie-hellman-group14-sha1"}, nil},
+		{"append unknown", []string{"+unknown-algorithm"}, []string{"curve25519-sha256", "ecdh-sha2-nistp256"}, nil},
+
+		{"remove", []string{"-ecdh-sha2-nistp256"}, []string{"curve25519-sha256"}, nil},
+		{"remove wildcard", []string{"-curve*"}, []string{"ecdh-sha2-nistp256"}, nil},
+		{"remove all", []string{"-*"}, nil, slices.ErrNoValue},
+
+		{"prepend", []string{"^ecdh-sha2-nistp256"}, []string{"ecdh-sha2-nistp256", "curve25519-sha256"}, nil},
+		{"prepend wildcard", []string{"^ecdh-*"}, []string{"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "curve25519-sha256"}, nil},
+		{"prepend unknown", []string{"^unknown-algorithm"}, []string{"curve25519-sha256", "ecdh-sha2-nistp256"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyAlgorithms(tt.spec, defaults, known)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("applyAlgorithms() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyAlgorithms() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if want := []string{"curve25519-sha256", "ecdh-sha2-nistp256"}; !reflect.DeepEqual(defaults, want) {
+		t.Errorf("applyAlgorithms() modified defaults to %v", defaults)
+	}
+}
+
+func Test_acceptedSigners(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner, err := ssh.NewSignerFromKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCertSigner := func(signer ssh.Signer) ssh.Signer {
+		cert := &ssh.Certificate{Key: signer.PublicKey(), CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+		if err := cert.SignCert(rand.Reader, edSigner); err != nil {
+			t.Fatal(err)
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certSigner
+	}
+	rsaCertSigner := newCertSigner(rsaSigner)
+	edCertSigner := newCertSigner(edSigner)
+
+	all := []ssh.Signer{rsaCertSigner, rsaSigner, edCertSigner, edSigner}
+
+	// signer describes an expected signer by its key type and signature algorithms
+	type signer struct {
+		keyType    string
+		algorithms []string
+	}
+
+	tests := []struct {
+		name     string
+		accepted []string
+		want     []signer
+	}{
+		{"unset", nil, []signer{
+			{ssh.CertAlgoRSAv01, []string{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA}},
+			{ssh.KeyAlgoRSA, []string{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA}},
+			{ssh.CertAlgoED25519v01, []string{ssh.KeyAlgoED25519}},
+			{ssh.KeyAlgoED25519, []string{ssh.KeyAlgoED25519}},
+		}},
+		{"empty", []string{}, []signer{}},
+		{"ed25519 only", []string{ssh.KeyAlgoED25519}, []signer{
+			{ssh.KeyAlgoED25519, []string{ssh.KeyAlgoED25519}},
+		}},
+		{"ed25519 certificates only", []string{ssh.CertAlgoED25519v01}, []signer{
+			{ssh.CertAlgoED25519v01, []string{ssh.KeyAlgoED25519}},
+		}},
+		{"rsa sha2-256", []string{ssh.KeyAlgoRSASHA256}, []signer{
+			{ssh.KeyAlgoRSA, []string{ssh.KeyAlgoRSASHA256}},
+		}},
+		{"rsa sha2 both", []string{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoED25519}, []signer{
+			{ssh.KeyAlgoRSA, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}},
+			{ssh.KeyAlgoED25519, []string{ssh.KeyAlgoED25519}},
+		}},
+		{"rsa certificate sha2-512", []string{ssh.CertAlgoRSASHA512v01}, []signer{
+			{ssh.CertAlgoRSAv01, []string{ssh.KeyAlgoRSASHA512}},
+		}},
+		{"rsa certificate and key", []string{ssh.CertAlgoRSASHA256v01, ssh.KeyAlgoRSA}, []signer{
+			{ssh.CertAlgoRSAv01, []string{ssh.KeyAlgoRSASHA256}},
+			{ssh.KeyAlgoRSA, []string{ssh.KeyAlgoRSA}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := acceptedSigners(all, tt.accepted)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("acceptedSigners() returned %d signers, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if keyType := got[i].PublicKey().Type(); keyType != want.keyType {
+					t.Errorf("acceptedSigners()[%d] has type %q, want %q", i, keyType, want.keyType)
+				}
+
+				ms, ok := got[i].(ssh.MultiAlgorithmSigner)
+				if !ok {
+					t.Errorf("acceptedSigners()[%d] is not a MultiAlgorithmSigner", i)
+					continue
+				}
+				if !reflect.DeepEqual(ms.Algorithms(), want.algorithms) {
+					t.Errorf("acceptedSigners()[%d] uses algorithms %v, want %v", i, ms.Algorithms(), want.algorithms)
+				}
+			}
+		})
+	}
+}
@@ -1,14 +1,35 @@
 package sshost
 
-import "golang.org/x/crypto/ssh"
+import (
+	"github.com/tkw1536/sshost/internal/pkg/slices"
+	"golang.org/x/crypto/ssh"
+)
 
 // This file contains variables that hold the names of algorithms supported by the ssh package.
-// Some of these come from private, some from public constants.
+// Key and certificate algorithms come from public constants.
+// Key exchanges, ciphers and MACs are taken from ssh.SupportedAlgorithms and ssh.InsecureAlgorithms.
+
+// algorithms implemented by the ssh package, in preference order
+var (
+	supportedAlgos = ssh.SupportedAlgorithms()
+	insecureAlgos  = ssh.InsecureAlgorithms()
+)
+
+// names of all algorithms with security issues
+var insecureAlgoNames = slices.Combine(
+	insecureAlgos.KeyExchanges,
+	insecureAlgos.Ciphers,
+	insecureAlgos.MACs,
+	insecureAlgos.HostKeys,
+	insecureAlgos.PublicKeyAuths,
+)
 
 // ssh.CertAlgo* constants
 var knownCertAlgos = []string{
+	ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSASHA512v01,
 	ssh.CertAlgoRSAv01,
-	ssh.CertAlgoDSAv01,
+	ssh.InsecureCertAlgoDSAv01,
 	ssh.CertAlgoECDSA256v01,
 	ssh.CertAlgoECDSA384v01,
 	ssh.CertAlgoECDSA521v01,
@@ -20,7 +41,7 @@ var knownCertAlgos = []string{
 // ssh.KeyAlgoRSA* constants
 var knownKeyAlgos = []string{
 	ssh.KeyAlgoRSA,
-	ssh.KeyAlgoDSA,
+	ssh.InsecureKeyAlgoDSA,
 	ssh.KeyAlgoECDSA256,
 	ssh.KeyAlgoSKECDSA256,
 	ssh.KeyAlgoECDSA384,
@@ -29,34 +50,47 @@ var knownKeyAlgos = []string{
 	ssh.KeyAlgoSKED25519,
 }
 
+// known signature algorithms
+// contains ssh.KeyAlgo* constants for signatures
+var knownSigAlgos = slices.Combine(knownKeyAlgos, []string{
+	ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSASHA512,
+})
+
 // known key exchange algorithms
-// contained in private ssh.kexAlgo* constants
-var knownKexAlgos = []string{
-	"diffie-hellman-group1-sha1",
-	"diffie-hellman-group14-sha1",
-	"ecdh-sha2-nistp256",
-	"ecdh-sha2-nistp384",
-	"ecdh-sha2-nistp521",
-	"curve25519-sha256@libssh.org",
-
-	// client only
-	"diffie-hellman-group-exchange-sha1",
-	"diffie-hellman-group-exchange-sha256",
-}
+// "curve25519-sha256@libssh.org" is an alias of ssh.KeyExchangeCurve25519 not listed by the ssh package.
+var knownKexAlgos = slices.Combine(
+	supportedAlgos.KeyExchanges,
+	insecureAlgos.KeyExchanges,
+	[]string{"curve25519-sha256@libssh.org"},
+)
 
 // known cipher names
-// contained in ssh.supportedCiphers
-var knownCiperNames = []string{
-	"aes128-ctr", "aes192-ctr", "aes256-ctr",
-	"aes128-gcm@openssh.com",
-	"chacha20-poly1305@openssh.com",
-	"arcfour256", "arcfour128", "arcfour",
-	"aes128-cbc",
-	"3des-cbc",
-}
+var knownCiperNames = slices.Combine(supportedAlgos.Ciphers, insecureAlgos.Ciphers)
+
+// known MAC names
+var knownMACNames = slices.Combine(supportedAlgos.MACs, insecureAlgos.MACs)
+
+// default algorithms, used as the base set for list modifiers (see applyAlgorithms).
+// These are the algorithms of the ssh package without security issues.
+
+var defaultKexAlgos = supportedAlgos.KeyExchanges
+var defaultCipherNames = supportedAlgos.Ciphers
+var defaultMACNames = supportedAlgos.MACs
+var defaultHostKeyAlgos = supportedAlgos.HostKeys
 
-// knownMACnames
-// contained in ssh.supportedMACs
-var knownMACNames = []string{
-	"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+// default public key algorithms, all certificate and signature algorithms
+var defaultPubkeyAlgos = slices.Combine(knownCertAlgos, knownSigAlgos)
+
+// default CA signature algorithms
+// taken from the default of OpenSSH
+var defaultCASigAlgos = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoSKED25519,
+	ssh.KeyAlgoSKECDSA256,
+	ssh.KeyAlgoRSASHA512,
+	ssh.KeyAlgoRSASHA256,
 }
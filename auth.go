@@ -1,14 +1,15 @@
 package sshost
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/tkw1536/sshost/internal/pkg/slices"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/term"
@@ -20,6 +21,11 @@ type AuthEnv struct {
 	Stdout io.Writer
 
 	PasswordPrompt string
+
+	// PassphrasePrompt is printed before reading the passphrase of an encrypted private key.
+	// Occurrences of "%s" are replaced by the path of the key.
+	// When empty, DefaultPassphrasePrompt is used.
+	PassphrasePrompt string
 }
 
 // in returns the input used for this environment
@@ -57,21 +63,60 @@ func (m AuthEnv) print(message string, newline bool) {
 	}
 }
 
-// readOpen openly reads text from standard input
+// readOpen openly reads a line of text from standard input.
+//
+// The input is read one byte at a time, so that nothing after the line is consumed.
+// Later prompts, and other readers of the input, continue where the line ended.
 func (m AuthEnv) readOpen() (string, error) {
-	reader := bufio.NewReader(m.in())
-	text, err := reader.ReadString('\n')
-	if err != nil {
-		return "", err
+	reader, ok := m.in().(io.ByteReader)
+	if !ok {
+		reader = byteReader{m.in()}
+	}
+
+	var line []byte
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			break
+		}
+		line = append(line, b)
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
+// byteReader reads single bytes from a reader, without buffering
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	for {
+		n, err := r.Read(b[:])
+		if n == 1 {
+			return b[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
 	}
-	return strings.TrimSpace(text), nil
 }
 
 // readClosed reads text from standard input, hiding the output.
+// When the input is not a terminal, reads openly instead.
 func (m AuthEnv) readClosed() (string, error) {
+	if _, ok := m.in().(*os.File); !ok || !term.IsTerminal(m.inFD()) {
+		return m.readOpen()
+	}
 	bytes, err := term.ReadPassword(m.inFD())
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 	return strings.TrimSpace(string(bytes)), nil
 }
@@ -90,7 +135,7 @@ const (
 
 // Methods gets all ssh methods specified in the comma-seperated string methods.
 // When a method does not exist, or is not supported, skips over it.
-func (m AuthEnv) Methods(methods string, profile Profile) []ssh.AuthMethod {
+func (m AuthEnv) Methods(methods string, profile *Profile) []ssh.AuthMethod {
 	names := strings.Split(methods, ",")
 	auths := make([]ssh.AuthMethod, 0)
 	for _, name := range names {
@@ -102,7 +147,7 @@ func (m AuthEnv) Methods(methods string, profile Profile) []ssh.AuthMethod {
 
 // Method gets the specified authentication method.
 // When the method does not exist, or is not supported, returns nil.
-func (m AuthEnv) Method(method AuthMethod, profile Profile) []ssh.AuthMethod {
+func (m AuthEnv) Method(method AuthMethod, profile *Profile) []ssh.AuthMethod {
 	switch method {
 	case PublicKey:
 		return m.mPublicKey(profile)
@@ -115,7 +160,7 @@ func (m AuthEnv) Method(method AuthMethod, profile Profile) []ssh.AuthMethod {
 }
 
 // mPassword returns the password authentication method.
-func (m AuthEnv) mPassword(profile Profile) []ssh.AuthMethod {
+func (m AuthEnv) mPassword(profile *Profile) []ssh.AuthMethod {
 	if !profile.config.PasswordAuthentication {
 		return nil
 	}
@@ -139,7 +184,7 @@ func (m AuthEnv) passwordCallback() (secret string, err error) {
 }
 
 // mKeyboardInteractive returns the keyboard-interactive authentication method.
-func (m AuthEnv) mKeyboardInteractive(profile Profile) []ssh.AuthMethod {
+func (m AuthEnv) mKeyboardInteractive(profile *Profile) []ssh.AuthMethod {
 	if !profile.config.KbdInteractiveAuthentication {
 		return nil
 	}
@@ -170,21 +215,26 @@ func (m AuthEnv) keyboardInteractiveCallback(user, instruction string, questions
 }
 
 // mPublicKey returns the public-key authentication method
-func (m AuthEnv) mPublicKey(profile Profile) []ssh.AuthMethod {
+func (m AuthEnv) mPublicKey(profile *Profile) []ssh.AuthMethod {
 	methods := make([]ssh.AuthMethod, 0)
 	if agent := m.publicKeyAgent(profile); agent != nil {
 		methods = append(methods, agent)
 	}
 	IdentityFile := profile.IdentityFile()
 	for _, file := range IdentityFile {
-		if pk := m.identityFile(file); pk != nil {
+		if pk := m.identityFile(profile, file); pk != nil {
 			methods = append(methods, pk)
 		}
 	}
 	return methods
 }
 
-func (m AuthEnv) identityFile(path string) ssh.AuthMethod {
+// identityFile returns the public-key authentication method for the private key at path.
+// Once a connection has been established using the key, it is added to the agent according to AddKeysToAgent.
+//
+// Valid certificates for the key are offered before the key itself, see certSigners.
+// When all of them have expired, only the key is offered, and the expiry is reported if connecting fails.
+func (m AuthEnv) identityFile(profile *Profile, path string) ssh.AuthMethod {
 	// read the bytes, it's fine if we can't read the file.
 	pkBytes, err := os.ReadFile(path)
 	if err != nil {
@@ -193,27 +243,106 @@ func (m AuthEnv) identityFile(path string) ssh.AuthMethod {
 
 	// decode the bytes as a public key, but error out if they can't be read!
 	return ssh.PublicKeysCallback(func() (signers []ssh.Signer, err error) {
-		signer, err := ssh.ParsePrivateKey(pkBytes)
+		key, err := m.parsePrivateKey(profile, path, pkBytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.NewSignerFromKey(key)
 		if err != nil {
 			return nil, err
 		}
-		return []ssh.Signer{signer}, nil
+		signer = profile.newLoadedSigner(path, key, signer)
+		signers, expired := certSigners(signer, profile.certificates(), time.Now())
+		if expired != nil {
+			profile.recordExpiredCert(path, expired)
+		}
+		return acceptedSigners(signers, profile.config.PubkeyAcceptedAlgorithms), nil
 	})
 }
 
-func (m AuthEnv) publicKeyAgent(profile Profile) ssh.AuthMethod {
+func (m AuthEnv) publicKeyAgent(profile *Profile) ssh.AuthMethod {
 	IdentityAgent := profile.IdentityAgent()
 	if profile.config.IdentitiesOnly || IdentityAgent == "" {
 		return nil
 	}
 
-	// read the identity agent!
+	// read the identity agent, and pair its keys with certificates
 	return ssh.PublicKeysCallback(func() (signers []ssh.Signer, err error) {
 		agentc, err := net.Dial("unix", IdentityAgent)
 		if err != nil {
 			return nil, err
 		}
 		client := agent.NewClient(agentc)
-		return client.Signers()
+		signers, err = client.Signers()
+		if err != nil {
+			return nil, err
+		}
+		signers = agentCertSigners(signers, profile.certificates(), time.Now())
+		return acceptedSigners(signers, profile.config.PubkeyAcceptedAlgorithms), nil
 	})
 }
+
+// acceptedSigners restricts signers to the algorithms in accepted, see PubkeyAcceptedAlgorithms.
+//
+// Signers that support none of the accepted algorithms are removed.
+// RSA signers are restricted to the accepted signature algorithms.
+// When accepted is nil, signers are returned unchanged.
+func acceptedSigners(signers []ssh.Signer, accepted []string) []ssh.Signer {
+	if accepted == nil {
+		return signers
+	}
+
+	results := make([]ssh.Signer, 0, len(signers))
+	for _, signer := range signers {
+		keyType := signer.PublicKey().Type()
+
+		// determine the accepted signature algorithms for the key.
+		// for certificates, these are the underlying algorithms of the accepted certificate algorithms.
+		var algorithms []string
+		for _, algo := range signatureAlgorithms(keyType) {
+			if slices.Contains(accepted, algo) {
+				algorithms = append(algorithms, underlyingAlgorithm(algo))
+			}
+		}
+		if len(algorithms) == 0 {
+			continue
+		}
+
+		// restrict signers that support multiple algorithms
+		if as, ok := signer.(ssh.AlgorithmSigner); ok && len(signatureAlgorithms(keyType)) > 1 {
+			restricted, err := ssh.NewSignerWithAlgorithms(as, algorithms)
+			if err != nil {
+				continue
+			}
+			signer = restricted
+		}
+		results = append(results, signer)
+	}
+	return results
+}
+
+// signatureAlgorithms returns the algorithms that can be used to authenticate with a key of the given type
+func signatureAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	default:
+		return []string{keyType}
+	}
+}
+
+// underlyingAlgorithm returns the signature algorithm underlying a certificate algorithm.
+// Other algorithms are returned unchanged.
+func underlyingAlgorithm(algo string) string {
+	switch algo {
+	case ssh.CertAlgoRSASHA512v01:
+		return ssh.KeyAlgoRSASHA512
+	case ssh.CertAlgoRSASHA256v01:
+		return ssh.KeyAlgoRSASHA256
+	case ssh.CertAlgoRSAv01:
+		return ssh.KeyAlgoRSA
+	}
+	return algo
+}
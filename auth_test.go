@@ -0,0 +1,63 @@
+package sshost
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuthEnv_readOpen(t *testing.T) {
+	tests := []struct {
+		name  string
+		input io.Reader
+	}{
+		{"byte reader", strings.NewReader("first\n second \nlast")},
+		{"plain reader", struct{ io.Reader }{strings.NewReader("first\n second \nlast")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := AuthEnv{Stdin: tt.input}
+			for _, want := range []string{"first", "second", "last"} {
+				got, err := m.readOpen()
+				if err != nil {
+					t.Fatalf("readOpen() error = %v", err)
+				}
+				if got != want {
+					t.Errorf("readOpen() = %q, want %q", got, want)
+				}
+			}
+			if _, err := m.readOpen(); err != io.EOF {
+				t.Errorf("readOpen() at end of input error = %v, want %v", err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestAuthEnv_readClosed(t *testing.T) {
+	// a pipe is a file, but not a terminal
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := io.WriteString(w, "wrong\ncorrect\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	m := AuthEnv{Stdin: r}
+	for _, want := range []string{"wrong", "correct"} {
+		got, err := m.readClosed()
+		if err != nil {
+			t.Fatalf("readClosed() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("readClosed() = %q, want %q", got, want)
+		}
+	}
+	if _, err := m.readClosed(); err == nil {
+		t.Error("readClosed() at end of input succeeded")
+	}
+}
@@ -0,0 +1,105 @@
+package sshost
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// ErrNoInterfaceAddress is returned when the interface given by BindInterface has no suitable address
+type ErrNoInterfaceAddress struct {
+	Interface     string
+	AddressFamily AddressFamily
+}
+
+func (err ErrNoInterfaceAddress) Error() string {
+	return fmt.Sprintf("interface %q has no address for AddressFamily %q", err.Interface, err.AddressFamily)
+}
+
+// localAddr returns the local address to use for direct connections to the host, as configured by BindAddress and BindInterface.
+// BindAddress takes precedence over BindInterface.
+// When neither is set, returns nil.
+//
+// When AddressFamily is "any", the address of BindInterface is chosen to match the family of the addresses of the host.
+// ctx is used to resolve the host.
+func (profile *Profile) localAddr(cfg Config, ctx context.Context) (net.Addr, error) {
+	switch {
+	case cfg.BindAddress != "":
+		return net.ResolveTCPAddr(cfg.AddressFamily.Network(), net.JoinHostPort(cfg.BindAddress, "0"))
+	case cfg.BindInterface != "":
+		ips, err := interfaceIPs(cfg.BindInterface, cfg.AddressFamily)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) > 0 && cfg.AddressFamily != IPv4AddressFamily && cfg.AddressFamily != IPv6AddressFamily {
+			hosts, err := profile.env.resolver().LookupHost(ctx, cfg.Hostname)
+			if err != nil {
+				return nil, err
+			}
+			ips = matchFamily(ips, hosts)
+		}
+		if len(ips) == 0 {
+			return nil, ErrNoInterfaceAddress{Interface: cfg.BindInterface, AddressFamily: cfg.AddressFamily}
+		}
+		return &net.TCPAddr{IP: ips[0]}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// interfaceIPs returns the addresses of the named interface that belong to family.
+// IPv6 link-local addresses are skipped, as they can not be used without a zone.
+func interfaceIPs(name string, family AddressFamily) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := ipnet.IP
+		isV4 := ip.To4() != nil
+		switch {
+		case family == IPv4AddressFamily && !isV4:
+			continue
+		case family == IPv6AddressFamily && isV4:
+			continue
+		case !isV4 && ip.IsLinkLocalUnicast():
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// matchFamily returns the addresses in ips that belong to the family of the first host that any of them belongs to.
+// hosts are textual IP addresses, in the order they are tried when connecting; other hosts are ignored.
+func matchFamily(ips []net.IP, hosts []string) []net.IP {
+	for _, host := range hosts {
+		remote := net.ParseIP(host)
+		if remote == nil {
+			continue
+		}
+		isV4 := remote.To4() != nil
+
+		var matches []net.IP
+		for _, ip := range ips {
+			if (ip.To4() != nil) == isV4 {
+				matches = append(matches, ip)
+			}
+		}
+		if len(matches) > 0 {
+			return matches
+		}
+	}
+	return nil
+}
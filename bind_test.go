@@ -0,0 +1,76 @@
+package sshost
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func Test_matchFamily(t *testing.T) {
+	v4 := net.ParseIP("192.0.2.1")
+	v6 := net.ParseIP("2001:db8::1")
+
+	tests := []struct {
+		name  string
+		ips   []net.IP
+		hosts []string
+		want  []net.IP
+	}{
+		{"ipv4 host", []net.IP{v6, v4}, []string{"198.51.100.1"}, []net.IP{v4}},
+		{"ipv6 host", []net.IP{v4, v6}, []string{"2001:db8::2"}, []net.IP{v6}},
+		{"first host wins", []net.IP{v4, v6}, []string{"2001:db8::2", "198.51.100.1"}, []net.IP{v6}},
+		{"fall back to later host", []net.IP{v4}, []string{"2001:db8::2", "198.51.100.1"}, []net.IP{v4}},
+		{"no matching family", []net.IP{v4}, []string{"2001:db8::2"}, nil},
+		{"invalid hosts are ignored", []net.IP{v4}, []string{"example.com", "198.51.100.1"}, []net.IP{v4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchFamily(tt.ips, tt.hosts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchFamily() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfile_localAddr(t *testing.T) {
+	// find a loopback interface with both an IPv4 and an IPv6 address
+	var name string
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		v4, _ := interfaceIPs(iface.Name, IPv4AddressFamily)
+		v6, _ := interfaceIPs(iface.Name, IPv6AddressFamily)
+		if len(v4) > 0 && len(v6) > 0 {
+			name = iface.Name
+			break
+		}
+	}
+	if name == "" {
+		t.Skip("no loopback interface with IPv4 and IPv6 addresses")
+	}
+
+	for _, hostname := range []string{"127.0.0.1", "::1"} {
+		t.Run(hostname, func(t *testing.T) {
+			profile := newTestProfile(t, "Hostname "+hostname+"\nBindInterface "+name+"\n")
+			cfg, err := profile.GetConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			addr, err := profile.localAddr(cfg, context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := addr.(*net.TCPAddr).IP
+			if want := net.ParseIP(hostname); (got.To4() != nil) != (want.To4() != nil) {
+				t.Errorf("localAddr() = %v, want an address of the same family as %v", got, want)
+			}
+		})
+	}
+}
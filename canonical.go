@@ -0,0 +1,138 @@
+package sshost
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/tkw1536/sshost/internal/pkg/pattern"
+)
+
+// CanonicalizeHostname specifies if hostnames are canonicalized.
+type CanonicalizeHostname string
+
+const (
+	// CanonicalizeHostnameNo never canonicalizes hostnames.
+	CanonicalizeHostnameNo CanonicalizeHostname = "no"
+
+	// CanonicalizeHostnameYes canonicalizes hostnames unless a ProxyJump or ProxyCommand is used.
+	CanonicalizeHostnameYes CanonicalizeHostname = "yes"
+
+	// CanonicalizeHostnameAlways always canonicalizes hostnames.
+	CanonicalizeHostnameAlways CanonicalizeHostname = "always"
+
+	DefaultCanonicalizeHostname = CanonicalizeHostnameNo
+)
+
+// Valid checks if the provided CanonicalizeHostname is valid
+func (c CanonicalizeHostname) Valid() bool {
+	return c == CanonicalizeHostnameNo || c == CanonicalizeHostnameYes || c == CanonicalizeHostnameAlways
+}
+
+// Resolver resolves hostnames during canonicalization.
+// It is implemented by *net.Resolver.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+	LookupCNAME(ctx context.Context, host string) (cname string, err error)
+}
+
+// resolver returns the resolver of this environment
+func (env Environment) resolver() Resolver {
+	if env.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return env.Resolver
+}
+
+// canonicalize canonicalizes hostname as configured in cfg.
+// When hostname is not canonicalized, returns the empty string.
+func (env Environment) canonicalize(cfg Config, hostname string, ctx context.Context) (string, error) {
+	if !cfg.CanonicalizeHostname.Valid() {
+		return "", NewErrField(nil, "CanonicalizeHostname")
+	}
+
+	switch {
+	case cfg.CanonicalizeHostname == CanonicalizeHostnameNo:
+		return "", nil
+	case cfg.CanonicalizeHostname == CanonicalizeHostnameYes && (len(cfg.ProxyJump) > 0 || (cfg.ProxyCommand != "" && cfg.ProxyCommand != "none")):
+		return "", nil
+	case net.ParseIP(hostname) != nil:
+		return "", nil
+	}
+
+	resolver := env.resolver()
+
+	// fully qualified names are only checked for cnames
+	if strings.HasSuffix(hostname, ".") {
+		name := strings.TrimSuffix(hostname, ".")
+		if _, err := resolver.LookupHost(ctx, name); err != nil {
+			return env.canonicalizeFallback(cfg, hostname)
+		}
+		return cfg.followCNAME(ctx, resolver, name), nil
+	}
+
+	if uint64(strings.Count(hostname, ".")) > cfg.CanonicalizeMaxDots {
+		return "", nil
+	}
+
+	for _, domain := range cfg.CanonicalDomains {
+		name := hostname + "." + domain
+		if _, err := resolver.LookupHost(ctx, name); err != nil {
+			continue
+		}
+		return cfg.followCNAME(ctx, resolver, name), nil
+	}
+
+	return env.canonicalizeFallback(cfg, hostname)
+}
+
+// canonicalizeFallback is called when canonicalization of hostname failed
+func (env Environment) canonicalizeFallback(cfg Config, hostname string) (string, error) {
+	if !cfg.CanonicalizeFallbackLocal {
+		return "", ErrCanonicalizeFailed{Hostname: hostname}
+	}
+	return "", nil
+}
+
+// followCNAME returns the canonical name of name if it is permitted by CanonicalizePermittedCNAMEs.
+// Otherwise returns name.
+func (cfg Config) followCNAME(ctx context.Context, resolver Resolver, name string) string {
+	cname, err := resolver.LookupCNAME(ctx, name)
+	if err != nil {
+		return name
+	}
+	cname = strings.TrimSuffix(cname, ".")
+	if strings.EqualFold(cname, name) {
+		return name
+	}
+
+	for _, rule := range cfg.CanonicalizePermittedCNAMEs {
+		source, target, ok := cutRule(rule)
+		if !ok {
+			continue
+		}
+		if pattern.MatchList(source, name, true) && pattern.MatchList(target, cname, true) {
+			return cname
+		}
+	}
+	return name
+}
+
+// cutRule splits a CanonicalizePermittedCNAMEs rule into source and target domain lists
+func cutRule(rule string) (source, target string, ok bool) {
+	index := strings.IndexRune(rule, ':')
+	if index <= 0 || index == len(rule)-1 {
+		return "", "", false
+	}
+	return rule[:index], rule[index+1:], true
+}
+
+// ErrCanonicalizeFailed is returned when a hostname could not be canonicalized, and CanonicalizeFallbackLocal is disabled.
+type ErrCanonicalizeFailed struct {
+	Hostname string
+}
+
+func (err ErrCanonicalizeFailed) Error() string {
+	return fmt.Sprintf("could not canonicalize hostname %q", err.Hostname)
+}
@@ -0,0 +1,100 @@
+package sshost
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeResolver is a Resolver that resolves a fixed set of names
+type fakeResolver struct {
+	hosts  []string          // names that resolve
+	cnames map[string]string // canonical names
+}
+
+var errNotFound = errors.New("not found")
+
+func (f fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	for _, h := range f.hosts {
+		if h == host {
+			return []string{"192.0.2.1"}, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (f fakeResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if cname, ok := f.cnames[host]; ok {
+		return cname + ".", nil
+	}
+	return host + ".", nil
+}
+
+func TestEnvironment_canonicalize(t *testing.T) {
+	env := Environment{
+		Resolver: fakeResolver{
+			hosts: []string{"web.b.example", "db.a.example", "mail.a.example", "web.sub.a.example"},
+			cnames: map[string]string{
+				"db.a.example":   "db.cdn.example",
+				"mail.a.example": "mail.other.example",
+			},
+		},
+	}
+
+	base := Config{
+		CanonicalizeHostname:        CanonicalizeHostnameYes,
+		CanonicalDomains:            []string{"a.example", "b.example"},
+		CanonicalizeMaxDots:         1,
+		CanonicalizeFallbackLocal:   true,
+		CanonicalizePermittedCNAMEs: []string{"*.a.example:*.cdn.example"},
+	}
+
+	tests := []struct {
+		name     string
+		hostname string
+		modify   func(cfg *Config)
+		want     string
+		wantErr  bool
+	}{
+		{"disabled", "web", func(cfg *Config) { cfg.CanonicalizeHostname = CanonicalizeHostnameNo }, "", false},
+		{"second domain", "web", nil, "web.b.example", false},
+		{"first domain only", "web", func(cfg *Config) { cfg.CanonicalDomains = []string{"a.example"} }, "", false},
+		{"dots within limit", "web.sub", nil, "web.sub.a.example", false},
+		{"too many dots", "web.sub", func(cfg *Config) { cfg.CanonicalizeMaxDots = 0 }, "", false},
+		{"fully qualified", "web.b.example.", nil, "web.b.example", false},
+		{"ip address", "192.0.2.1", nil, "", false},
+		{"yes with proxyjump", "web", func(cfg *Config) { cfg.ProxyJump = []string{"jump"} }, "", false},
+		{"always with proxyjump", "web", func(cfg *Config) {
+			cfg.CanonicalizeHostname = CanonicalizeHostnameAlways
+			cfg.ProxyJump = []string{"jump"}
+		}, "web.b.example", false},
+
+		{"fallback local", "unknown", nil, "", false},
+		{"no fallback local", "unknown", func(cfg *Config) { cfg.CanonicalizeFallbackLocal = false }, "", true},
+		{"no fallback local fully qualified", "unknown.example.", func(cfg *Config) { cfg.CanonicalizeFallbackLocal = false }, "", true},
+
+		{"permitted cname", "db", nil, "db.cdn.example", false},
+		{"unpermitted cname", "mail", nil, "mail.a.example", false},
+		{"no permitted cnames", "db", func(cfg *Config) { cfg.CanonicalizePermittedCNAMEs = nil }, "db.a.example", false},
+		{"invalid cname rule", "db", func(cfg *Config) { cfg.CanonicalizePermittedCNAMEs = []string{"*.a.example"} }, "db.a.example", false},
+
+		{"invalid mode", "web", func(cfg *Config) { cfg.CanonicalizeHostname = "sometimes" }, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+
+			got, err := env.canonicalize(cfg, tt.hostname, context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Environment.canonicalize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Environment.canonicalize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package sshost
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+	"golang.org/x/crypto/ssh"
+)
+
+var certificateFileFlags = expand.Flags{
+	Environment: true,
+	Tilde:       true,
+	Tokens:      "%CdhijkLlnpru",
+}
+
+// CertificateFile returns the CertificateFile being used by this profile
+func (profile *Profile) CertificateFile() []string {
+	result := make([]string, 0, len(profile.config.CertificateFile))
+	ex := profile.expander()
+	for _, file := range profile.config.CertificateFile {
+		name, err := ex.Expand(file, certificateFileFlags)
+		if err != nil {
+			continue
+		}
+		result = append(result, name)
+	}
+	return result
+}
+
+// ErrCertificateExpired is returned when connecting fails, and all certificates for a private key offered during authentication had expired.
+// The plain key is still offered in that case.
+type ErrCertificateExpired struct {
+	Path        string // path of the private key
+	ValidBefore time.Time
+
+	Err error // the error connecting failed with
+}
+
+func (err ErrCertificateExpired) Error() string {
+	message := fmt.Sprintf("certificate for %q expired at %s", err.Path, err.ValidBefore.Format(time.RFC3339))
+	if err.Err == nil {
+		return message
+	}
+	return message + ": " + err.Err.Error()
+}
+
+func (err ErrCertificateExpired) Unwrap() error {
+	return err.Err
+}
+
+// recordExpiredCert records that all certificates for the private key at path, the last of which is cert, have expired.
+// Only the first record is kept, it is reported by authFailed.
+func (profile *Profile) recordExpiredCert(path string, cert *ssh.Certificate) {
+	profile.authM.Lock()
+	defer profile.authM.Unlock()
+
+	if profile.expired != nil {
+		return
+	}
+	profile.expired = &ErrCertificateExpired{
+		Path:        path,
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0),
+	}
+}
+
+// authFailed is called when connecting failed with err, and forgets the state recorded during authentication.
+// When an expired certificate was recorded, returns an ErrCertificateExpired wrapping err.
+// Otherwise returns err unchanged.
+func (profile *Profile) authFailed(err error) error {
+	profile.authM.Lock()
+	defer profile.authM.Unlock()
+
+	expired := profile.expired
+	profile.expired = nil
+	profile.loaded = nil
+
+	if expired == nil {
+		return err
+	}
+	expired.Err = err
+	return *expired
+}
+
+// readCertificate reads a user certificate from path
+func readCertificate(path string) (*ssh.Certificate, bool) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(bytes)
+	if err != nil {
+		return nil, false
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, false
+	}
+	return cert, true
+}
+
+// certificates returns the user certificates from CertificateFile, and from the "-cert.pub" files next to each identity file.
+// Certificates that can not be read are skipped.
+func (profile *Profile) certificates() []*ssh.Certificate {
+	var paths []string
+	for _, file := range profile.IdentityFile() {
+		paths = append(paths, file+"-cert.pub")
+	}
+	paths = append(paths, profile.CertificateFile()...)
+
+	certs := make([]*ssh.Certificate, 0, len(paths))
+	for _, path := range paths {
+		if cert, ok := readCertificate(path); ok {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// certValid checks if cert is valid at the given time
+func certValid(cert *ssh.Certificate, now time.Time) bool {
+	unix := uint64(now.Unix())
+	return unix >= cert.ValidAfter && (cert.ValidBefore == ssh.CertTimeInfinity || unix < cert.ValidBefore)
+}
+
+// certSigners returns signers for all valid certificates in certs of the key of signer, followed by signer itself.
+//
+// When there are certificates for the key, but all of them have expired, expired is the last of them.
+func certSigners(signer ssh.Signer, certs []*ssh.Certificate, now time.Time) (signers []ssh.Signer, expired *ssh.Certificate) {
+	key := signer.PublicKey().Marshal()
+
+	for _, cert := range certs {
+		if !bytes.Equal(cert.Key.Marshal(), key) {
+			continue
+		}
+		if !certValid(cert, now) {
+			if cert.ValidBefore != ssh.CertTimeInfinity && uint64(now.Unix()) >= cert.ValidBefore {
+				expired = cert
+			}
+			continue
+		}
+
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, certSigner)
+	}
+
+	if len(signers) != 0 {
+		expired = nil
+	}
+	return append(signers, signer), expired
+}
+
+// agentCertSigners pairs the signers held by an agent with certificates.
+//
+// For each signer, valid certificates in certs for its key are added before it.
+// Expired certificates, including those held by the agent, are skipped.
+func agentCertSigners(signers []ssh.Signer, certs []*ssh.Certificate, now time.Time) []ssh.Signer {
+	results := make([]ssh.Signer, 0, len(signers))
+	for _, signer := range signers {
+		if cert, ok := signer.PublicKey().(*ssh.Certificate); ok {
+			if certValid(cert, now) {
+				results = append(results, signer)
+			}
+			continue
+		}
+
+		paired, _ := certSigners(signer, certs, now)
+		results = append(results, paired...)
+	}
+	return results
+}
@@ -0,0 +1,112 @@
+package sshost
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_certSigners(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+
+	ca := newSigner()
+	signer := newSigner()
+	other := newSigner()
+
+	now := time.Unix(1_000_000, 0)
+	newCert := func(key ssh.PublicKey, validAfter, validBefore uint64) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:         key,
+			CertType:    ssh.UserCert,
+			ValidAfter:  validAfter,
+			ValidBefore: validBefore,
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	valid := newCert(signer.PublicKey(), 0, ssh.CertTimeInfinity)
+	expired := newCert(signer.PublicKey(), 0, 999_999)
+	future := newCert(signer.PublicKey(), 1_000_001, ssh.CertTimeInfinity)
+	unrelated := newCert(other.PublicKey(), 0, ssh.CertTimeInfinity)
+
+	tests := []struct {
+		name        string
+		certs       []*ssh.Certificate
+		wantCerts   []*ssh.Certificate
+		wantExpired *ssh.Certificate
+	}{
+		{"no certificates", nil, nil, nil},
+		{"valid", []*ssh.Certificate{valid}, []*ssh.Certificate{valid}, nil},
+		{"unrelated", []*ssh.Certificate{unrelated}, nil, nil},
+		{"not yet valid", []*ssh.Certificate{future}, nil, nil},
+		{"expired", []*ssh.Certificate{expired}, nil, expired},
+		{"expired and valid", []*ssh.Certificate{expired, valid, unrelated}, []*ssh.Certificate{valid}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signers, gotExpired := certSigners(signer, tt.certs, now)
+			if gotExpired != tt.wantExpired {
+				t.Errorf("certSigners() expired = %v, want %v", gotExpired, tt.wantExpired)
+			}
+
+			// the plain key is always offered last
+			if len(signers) != len(tt.wantCerts)+1 {
+				t.Fatalf("certSigners() returned %d signers, want %d", len(signers), len(tt.wantCerts)+1)
+			}
+			if last := signers[len(signers)-1]; last != signer {
+				t.Errorf("certSigners() did not return the plain key last")
+			}
+			for i, cert := range tt.wantCerts {
+				if got := signers[i].PublicKey(); got != cert {
+					t.Errorf("certSigners()[%d] = %v, want certificate %v", i, got, cert)
+				}
+			}
+		})
+	}
+}
+
+func TestProfile_authFailed(t *testing.T) {
+	errAuth := errors.New("unable to authenticate")
+	profile := &Profile{}
+
+	if err := profile.authFailed(errAuth); err != errAuth {
+		t.Errorf("authFailed() without expired certificate = %v, want %v", err, errAuth)
+	}
+
+	profile.recordExpiredCert("/home/user/.ssh/id_ed25519", &ssh.Certificate{ValidBefore: 999_999})
+	profile.recordExpiredCert("/home/user/.ssh/id_rsa", &ssh.Certificate{ValidBefore: 888_888})
+
+	err := profile.authFailed(errAuth)
+	var expired ErrCertificateExpired
+	if !errors.As(err, &expired) {
+		t.Fatalf("authFailed() = %v, want ErrCertificateExpired", err)
+	}
+	if expired.Path != "/home/user/.ssh/id_ed25519" || !expired.ValidBefore.Equal(time.Unix(999_999, 0)) {
+		t.Errorf("authFailed() = %#v, want the first recorded certificate", expired)
+	}
+	if !errors.Is(err, errAuth) {
+		t.Errorf("authFailed() does not wrap %v", errAuth)
+	}
+
+	// the record is forgotten afterwards
+	if err := profile.authFailed(errAuth); err != errAuth {
+		t.Errorf("second authFailed() = %v, want %v", err, errAuth)
+	}
+}
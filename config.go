@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/tkw1536/sshost/internal/pkg/host"
+	"github.com/tkw1536/sshost/internal/pkg/slices"
 	"github.com/tkw1536/stringreader"
 )
 
@@ -16,23 +17,66 @@ import (
 type Config struct {
 	AddressFamily AddressFamily `config:"AddressFamily" type:"string"`
 	Hostname      string        `config:"Hostname" type:"string"`
-	Username      string        `config:"Username" type:"string"`
+	Username      string        `config:"User" type:"string"`
 	Port          uint16        `config:"Port" type:"uint"`
 
+	BindAddress   string `config:"BindAddress" type:"string"`
+	BindInterface string `config:"BindInterface" type:"string"`
+
+	CanonicalDomains            []string             `config:"CanonicalDomains" type:"stringfields"`
+	CanonicalizeFallbackLocal   bool                 `config:"CanonicalizeFallbackLocal" type:"yesno"`
+	CanonicalizeHostname        CanonicalizeHostname `config:"CanonicalizeHostname" type:"keyword"`
+	CanonicalizeMaxDots         uint64               `config:"CanonicalizeMaxDots" type:"uint"`
+	CanonicalizePermittedCNAMEs []string             `config:"CanonicalizePermittedCNAMEs" type:"stringfields"`
+
 	Ciphers       []string `config:"Ciphers" type:"stringslice"`
 	KexAlgorithms []string `config:"KexAlgorithms" type:"stringslice"`
 	MACs          []string `config:"MACs" type:"stringslice"`
 
-	Compression bool `config:"Compression" type:"yesno"`
+	Compression bool `config:"Compression" type:"yesno"` // not supported, see ErrCompressionUnsupported
 
 	ProxyJump []string `config:"ProxyJump" type:"stringslices"` // TODO: multi-slice
 
+	ProxyCommand   string `config:"ProxyCommand" type:"string"`
+	ProxyUseFdpass bool   `config:"ProxyUseFdpass" type:"yesno"`
+
+	ControlMaster  ControlMaster `config:"ControlMaster" type:"keyword"`
+	ControlPath    string        `config:"ControlPath" type:"string"`
+	ControlPersist time.Duration `config:"ControlPersist" type:"persist"`
+
+	ClearAllForwardings   bool     `config:"ClearAllForwardings" type:"yesno"`
+	DynamicForward        []string `config:"DynamicForward" type:"strings"`
+	ExitOnForwardFailure  bool     `config:"ExitOnForwardFailure" type:"yesno"`
+	GatewayPorts          bool     `config:"GatewayPorts" type:"yesno"`
+	LocalForward          []string `config:"LocalForward" type:"strings"`
+	RemoteForward         []string `config:"RemoteForward" type:"strings"`
+	StreamLocalBindMask   uint64   `config:"StreamLocalBindMask" type:"uint"`
+	StreamLocalBindUnlink bool     `config:"StreamLocalBindUnlink" type:"yesno"`
+
 	ConnectTimeout     time.Duration `config:"ConnectTimeout" type:"seconds"`
 	ConnectionAttempts uint64        `config:"ConnectionAttempts" type:"int"`
 
 	HostKeyAlgorithms []string `config:"HostKeyAlgorithms" type:"stringslice"`
 
-	RekeyLimit string `config:"RekeyLimit" type:"string"` // TODO: Proper datatype
+	CASignatureAlgorithms []string `config:"CASignatureAlgorithms" type:"stringslice"`
+	RevokedHostKeys       string   `config:"RevokedHostKeys" type:"string"`
+
+	GlobalKnownHostsFile []string `config:"GlobalKnownHostsFile" type:"stringfields"`
+	UserKnownHostsFile   []string `config:"UserKnownHostsFile" type:"stringfields"`
+
+	StrictHostKeyChecking StrictHostKeyChecking `config:"StrictHostKeyChecking" type:"keyword"`
+
+	// HostKeyFingerprint is the expected fingerprint of the host key.
+	// It is not read from the configuration, but set from the host, see UpdateHost.
+	HostKeyFingerprint string
+
+	// OriginalHost is the host as given by the user.
+	// It is not read from the configuration, but set from the host, see UpdateHost.
+	OriginalHost string
+
+	// RekeyLimit specifies when session keys are renegotiated.
+	// Only the data limit is enforced, see ErrRekeyTimeUnsupported.
+	RekeyLimit RekeyLimit `config:"RekeyLimit" type:"rekeylimit"`
 
 	ServerAliveCountMax uint64        `config:"ServerAliveCountMax" type:"uint"`
 	ServerAliveInterval time.Duration `config:"ServerAliveInterval" type:"seconds"`
@@ -43,10 +87,17 @@ type Config struct {
 	HostbasedAuthentication          bool `config:"HostbasedAuthentication" type:"yesno"`
 	NoHostAuthenticationForLocalhost bool `config:"NoHostAuthenticationForLocalhost" type:"yesno"`
 
+	AddKeysToAgent AddKeysToAgent `config:"AddKeysToAgent" type:"addkeys"`
+	ForwardAgent   string         `config:"ForwardAgent" type:"string"`
+
+	CertificateFile []string `config:"CertificateFile" type:"strings"`
+
 	IdentitiesOnly bool     `config:"IdentitiesOnly" type:"yesno"`
 	IdentityAgent  string   `config:"IdentityAgent" type:"string"`
 	IdentityFile   []string `config:"IdentityFile" type:"stringslice"`
 
+	PubkeyAcceptedAlgorithms []string `config:"PubkeyAcceptedAlgorithms" type:"stringslice"`
+
 	KbdInteractiveAuthentication bool `config:"KbdInteractiveAuthentication" type:"yesno"`
 
 	NumberOfPasswordPrompts int  `config:"NumberOfPasswordPrompts" type:"int"`
@@ -71,8 +122,40 @@ func (dflts Defaults) Data() (data stringreader.ParsingData) {
 
 	data.SetLocal("AddressFamily", "default", string(DefaultAddressFamily))
 
+	data.SetLocal("BindAddress", "default", "")
+
+	data.SetLocal("BindInterface", "default", "")
+
+	data.SetLocal("CanonicalDomains", "default", nil)
+
+	data.SetLocal("CanonicalizeFallbackLocal", "default", true)
+
+	data.SetLocal("CanonicalizeHostname", "default", string(DefaultCanonicalizeHostname))
+
+	data.SetLocal("CanonicalizeMaxDots", "default", 1)
+	data.SetLocal("CanonicalizeMaxDots", "base", 10)
+	data.SetLocal("CanonicalizeMaxDots", "bits", 64)
+
+	data.SetLocal("CanonicalizePermittedCNAMEs", "default", nil)
+
 	data.SetLocal("HostKeyAlgorithms", "default", nil)
 
+	data.SetLocal("CASignatureAlgorithms", "default", slices.Combine(defaultCASigAlgos))
+
+	data.SetLocal("RevokedHostKeys", "default", "")
+
+	data.SetLocal("GlobalKnownHostsFile", "default", []string{
+		"/etc/ssh/ssh_known_hosts",
+		"/etc/ssh/ssh_known_hosts2",
+	})
+
+	data.SetLocal("UserKnownHostsFile", "default", []string{
+		"~/.ssh/known_hosts",
+		"~/.ssh/known_hosts2",
+	})
+
+	data.SetLocal("StrictHostKeyChecking", "default", string(DefaultStrictHostKeyChecking))
+
 	data.SetLocal("Ciphers", "default", nil)
 
 	data.SetLocal("Compression", "default", false)
@@ -81,6 +164,30 @@ func (dflts Defaults) Data() (data stringreader.ParsingData) {
 	data.SetLocal("ConnectionAttempts", "base", 10)
 	data.SetLocal("ConnectionAttempts", "bits", 64)
 
+	data.SetLocal("ControlMaster", "default", string(DefaultControlMaster))
+
+	data.SetLocal("ControlPath", "default", "")
+
+	data.SetLocal("ControlPersist", "default", time.Duration(0))
+
+	data.SetLocal("ClearAllForwardings", "default", false)
+
+	data.SetLocal("DynamicForward", "default", nil)
+
+	data.SetLocal("ExitOnForwardFailure", "default", false)
+
+	data.SetLocal("GatewayPorts", "default", false)
+
+	data.SetLocal("LocalForward", "default", nil)
+
+	data.SetLocal("RemoteForward", "default", nil)
+
+	data.SetLocal("StreamLocalBindMask", "default", 0177)
+	data.SetLocal("StreamLocalBindMask", "base", 8)
+	data.SetLocal("StreamLocalBindMask", "bits", 32)
+
+	data.SetLocal("StreamLocalBindUnlink", "default", false)
+
 	data.SetLocal("ConnectTimeout", "default", time.Second)
 
 	data.SetLocal("KexAlgorithms", "default", nil)
@@ -93,11 +200,15 @@ func (dflts Defaults) Data() (data stringreader.ParsingData) {
 	data.SetLocal("ServerAliveCountMax", "base", 10)
 	data.SetLocal("ServerAliveCountMax", "bits", 64)
 
-	data.SetLocal("RekeyLimit", "default", "default none")
+	data.SetLocal("RekeyLimit", "default", RekeyLimit{})
 
 	data.SetLocal("ProxyJump", "default", nil)
 	data.SetLocal("ProxyJump", "skip", "none")
 
+	data.SetLocal("ProxyCommand", "default", "")
+
+	data.SetLocal("ProxyUseFdpass", "default", false)
+
 	data.SetLocal("PreferredAuthentications", "default", "gssapi-with-mic,hostbased,publickey,keyboard-interactive,password")
 
 	data.SetLocal("GSSAPIAuthentication", "default", false)
@@ -106,6 +217,12 @@ func (dflts Defaults) Data() (data stringreader.ParsingData) {
 
 	data.SetLocal("NoHostAuthenticationForLocalhost", "default", false)
 
+	data.SetLocal("AddKeysToAgent", "default", AddKeysToAgent{Mode: DefaultAddKeysMode})
+
+	data.SetLocal("ForwardAgent", "default", "no")
+
+	data.SetLocal("CertificateFile", "default", nil)
+
 	data.SetLocal("IdentitiesOnly", "default", false)
 
 	data.SetLocal("IdentityAgent", "default", "SSH_AUTH_SOCK")
@@ -127,6 +244,8 @@ func (dflts Defaults) Data() (data stringreader.ParsingData) {
 
 	data.SetLocal("PasswordAuthentication", "default", true)
 
+	data.SetLocal("PubkeyAcceptedAlgorithms", "default", nil)
+
 	return
 }
 
@@ -146,6 +265,7 @@ func NewConfig(source stringreader.Source, host host.Host, dflts Defaults) (cfg
 
 // UpdateFromHost updates config with data from the provided host
 func (cfg *Config) UpdateHost(host host.Host) error {
+	cfg.OriginalHost = host.Host
 	if cfg.Hostname == "" {
 		cfg.Hostname = host.Host
 	}
@@ -156,6 +276,9 @@ func (cfg *Config) UpdateHost(host host.Host) error {
 	if host.Port != 0 {
 		cfg.Port = host.Port
 	}
+	if host.Fingerprint != "" {
+		cfg.HostKeyFingerprint = host.Fingerprint
+	}
 	return nil
 }
 
@@ -174,6 +297,14 @@ func init() {
 		return value, nil
 	})
 
+	// keyword parses one of a fixed set of values, which are matched case-insensitively
+	configMarshal.RegisterSingleParser("keyword", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
+		if !ok || value == "" {
+			return ctx.Get("default"), nil
+		}
+		return strings.ToLower(strings.TrimSpace(value)), nil
+	})
+
 	configMarshal.RegisterSingleParser("stringslice", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
 		if !ok {
 			return ctx.Get("default"), nil
@@ -183,6 +314,13 @@ func init() {
 		}
 		return strings.Split(value, ","), nil
 	})
+	configMarshal.RegisterSingleParser("stringfields", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
+		if !ok || value == "" {
+			return ctx.Get("default"), nil
+		}
+		return strings.Fields(value), nil
+	})
+
 	configMarshal.RegisterMultiParser("stringslices", func(values []string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
 		if !ok {
 			return ctx.Get("default"), nil
@@ -211,6 +349,20 @@ func init() {
 		return results, nil
 	})
 
+	configMarshal.RegisterMultiParser("strings", func(values []string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
+		if !ok {
+			return ctx.Get("default"), nil
+		}
+		var results []string
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			results = append(results, value)
+		}
+		return results, nil
+	})
+
 	configMarshal.RegisterSingleParser("int", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
 		if !ok || value == "" {
 			return ctx.Get("default"), nil
@@ -225,15 +377,48 @@ func init() {
 		return strconv.ParseUint(value, ctx.Get("base").(int), ctx.Get("bits").(int))
 	})
 
+	// seconds parses a time in the sshd_config(5) TIME FORMATS, where plain numbers are seconds
 	configMarshal.RegisterSingleParser("seconds", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
 		if !ok || value == "" {
 			return ctx.Get("default"), nil
 		}
-		s, err := strconv.ParseInt(value, 10, 64)
-		if err == nil {
-			return 0, err
+		return parseTimeFormat(value)
+	})
+
+	configMarshal.RegisterSingleParser("persist", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
+		if !ok || value == "" {
+			return ctx.Get("default"), nil
+		}
+
+		switch strings.ToLower(value) {
+		case "yes":
+			return ControlPersistForever, nil
+		case "no":
+			return time.Duration(0), nil
+		}
+
+		d, err := parseTimeFormat(value)
+		if err != nil {
+			return time.Duration(0), err
+		}
+		if d == 0 {
+			return ControlPersistForever, nil
+		}
+		return d, nil
+	})
+
+	configMarshal.RegisterSingleParser("addkeys", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
+		if !ok || value == "" {
+			return ctx.Get("default"), nil
+		}
+		return parseAddKeysToAgent(value)
+	})
+
+	configMarshal.RegisterSingleParser("rekeylimit", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
+		if !ok || value == "" {
+			return ctx.Get("default"), nil
 		}
-		return time.Duration(s) * time.Second, nil
+		return parseRekeyLimit(value)
 	})
 
 	configMarshal.RegisterSingleParser("yesno", func(value string, ok bool, ctx stringreader.UnmarshalContext) (interface{}, error) {
@@ -254,3 +439,53 @@ func init() {
 
 // ErrNotABoolean is returned when a value is not a boolean
 var ErrNotABoolean = errors.New("received non-boolean value")
+
+// ErrNotATime is returned when a value is not a valid time interval
+var ErrNotATime = errors.New("received invalid time interval")
+
+// parseTimeFormat parses a time interval in the format described in the TIME FORMATS section of ssh_config(5).
+// It consists of a sequence of numbers, each optionally followed by a unit:
+// s (seconds, the default), m (minutes), h (hours), d (days) or w (weeks).
+func parseTimeFormat(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, ErrNotATime
+	}
+
+	var total time.Duration
+	for value != "" {
+		index := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+		if index == 0 {
+			return 0, ErrNotATime
+		}
+		if index < 0 {
+			index = len(value)
+		}
+
+		number, err := strconv.ParseInt(value[:index], 10, 64)
+		if err != nil {
+			return 0, ErrNotATime
+		}
+		value = value[index:]
+
+		unit := time.Second
+		if value != "" {
+			switch value[0] {
+			case 's', 'S':
+			case 'm', 'M':
+				unit = time.Minute
+			case 'h', 'H':
+				unit = time.Hour
+			case 'd', 'D':
+				unit = 24 * time.Hour
+			case 'w', 'W':
+				unit = 7 * 24 * time.Hour
+			default:
+				return 0, ErrNotATime
+			}
+			value = value[1:]
+		}
+
+		total += time.Duration(number) * unit
+	}
+	return total, nil
+}
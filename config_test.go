@@ -0,0 +1,105 @@
+package sshost
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvironment_NewConfig_user(t *testing.T) {
+	env := newTestEnvironment(t, "Host example.com\n\tUser alice\n")
+
+	tests := []struct {
+		alias string
+		want  string
+	}{
+		{"example.com", "alice"},
+		{"bob@example.com", "bob"},
+		{"other.com", "user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			cfg, err := env.NewConfig(tt.alias)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Username != tt.want {
+				t.Errorf("NewConfig().Username = %q, want %q", cfg.Username, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvironment_NewConfig_seconds(t *testing.T) {
+	tests := []struct {
+		name                    string
+		config                  string
+		wantConnectTimeout      time.Duration
+		wantServerAliveInterval time.Duration
+		wantErr                 bool
+	}{
+		{"default", "", time.Second, 0, false},
+		{"set", "ConnectTimeout 10\nServerAliveInterval 15\n", 10 * time.Second, 15 * time.Second, false},
+		{"zero", "ConnectTimeout 0\n", 0, 0, false},
+		{"invalid connect timeout", "ConnectTimeout ten\n", 0, 0, true},
+		{"time format", "ConnectTimeout 1m30s\nServerAliveInterval 1m\n", 90 * time.Second, time.Minute, false},
+		{"invalid server alive interval", "ServerAliveInterval 1x\n", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := newTestEnvironment(t, tt.config).NewConfig("example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.ConnectTimeout != tt.wantConnectTimeout {
+				t.Errorf("NewConfig().ConnectTimeout = %v, want %v", cfg.ConnectTimeout, tt.wantConnectTimeout)
+			}
+			if cfg.ServerAliveInterval != tt.wantServerAliveInterval {
+				t.Errorf("NewConfig().ServerAliveInterval = %v, want %v", cfg.ServerAliveInterval, tt.wantServerAliveInterval)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_compression(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		disabled := newTestConfig(t, "Compression no\n")
+		if err := disabled.Validate(strict); err != nil {
+			t.Errorf("Validate(%v) with Compression no error = %v", strict, err)
+		}
+		enabled := newTestConfig(t, "Compression yes\n")
+		if err := enabled.Validate(strict); !errors.Is(err, ErrCompressionUnsupported) {
+			t.Errorf("Validate(%v) with Compression yes error = %v, want %v", strict, err, ErrCompressionUnsupported)
+		}
+	}
+}
+
+func TestEnvironment_NewConfig_keyword(t *testing.T) {
+	tests := []struct {
+		config string
+		get    func(cfg Config) string
+		want   string
+	}{
+		{"StrictHostKeyChecking Yes\n", func(cfg Config) string { return string(cfg.StrictHostKeyChecking) }, "yes"},
+		{"StrictHostKeyChecking ACCEPT-NEW\n", func(cfg Config) string { return string(cfg.StrictHostKeyChecking) }, "accept-new"},
+		{"ControlMaster Auto\n", func(cfg Config) string { return string(cfg.ControlMaster) }, "auto"},
+		{"ControlMaster AutoAsk\n", func(cfg Config) string { return string(cfg.ControlMaster) }, "autoask"},
+		{"CanonicalizeHostname No\n", func(cfg Config) string { return string(cfg.CanonicalizeHostname) }, "no"},
+		{"CanonicalizeHostname ALWAYS\nCanonicalizeFallbackLocal yes\n", func(cfg Config) string { return string(cfg.CanonicalizeHostname) }, "always"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.TrimSpace(tt.config), func(t *testing.T) {
+			cfg := newTestConfig(t, tt.config)
+			if err := cfg.Validate(true); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if got := tt.get(cfg); got != tt.want {
+				t.Errorf("NewConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,75 +8,76 @@ import (
 
 // list of security-critical unsupported configs
 var unsupportedConfigs = []string{
-	// "AddKeysToAgent", // we don't need to retain access to keys
+	// "AddKeysToAgent",
 	// "BatchMode", // always in batch mode, connection may fail
-	"BindAddress",
-	"CanonicalDomains",
+	// "BindAddress",
+	// "BindInterface",
+	// "CanonicalDomains",
 	// "CanonicalizeFallbackLocal",
 	// "CanonicalizeHostname",
 	// "CanonicalizeMaxDots",
-	"CanonicalizePermittedCNAMEs",
-	"CASignatureAlgorithms",
-	"CertificateFile",
+	// "CanonicalizePermittedCNAMEs",
+	// "CASignatureAlgorithms",
+	// "CertificateFile",
 	// "CheckHostIP", // TODO: implement me!
 	// "ClearAllForwardings",
 
-	"DynamicForward",
+	// "DynamicForward",
 	// "EscapeChar", // TODO: Support by user
 	// "FingerprintHash", // TODO: Just used for output!
 
-	// "GlobalKnownHostsFile", // TODO: Support me!
+	// "GlobalKnownHostsFile",
 	// "HostbasedAcceptedAlgorithms",
 	"HostKeyAlias",
 	"IPQoS",
 	"KbdInteractiveDevices",
 	"KnownHostsCommand",
 	"LocalCommand",
-	"LocalForward",
+	// "LocalForward",
 	// "LogLevel", // TODO: Can we safely ignore this?
 	"PermitRemoteOpen",
 	"PKCS11Provider",
 	// "PreferredAuthentications", // TODO: Support authentications properly!
-	"ProxyCommand",
-	// "ProxyUseFdpass", // ProxyCommand is unsupported!
-	"PubkeyAcceptedAlgorithms",
+	// "ProxyCommand",
+	// "ProxyUseFdpass",
+	// "PubkeyAcceptedAlgorithms",
 	// "PubkeyAuthentication", // TODO: Support authentication properly!
-	// "RekeyLimit", // TODO: Support this properly!
+	// "RekeyLimit",
 	"RemoteCommand",
-	"RemoteForward",
+	// "RemoteForward",
 	"RequestTTY",
 	"SendEnv",
-	// "ServerAliveCountMax", // TODO: Support ServerAliveInterval
+	// "ServerAliveCountMax",
 	"SessionType",
 	"SetEnv",
 	"StdinNull",
 	// "StreamLocalBindMask",
 	// "StreamLocalBindUnlink",
-	// "StrictHostKeyChecking", // TODO: Support me!
+	// "StrictHostKeyChecking",
 	// "TCPKeepAlive", // TODO: Enabled by default!
-	// "UserKnownHostsFile",  // TODO: Support authentication properly!
+	// "UserKnownHostsFile",
 	// "VerifyHostKeyDNS", // TODO: Support properly!
 	// "XAuthLocation", // TODO: Support authentication properly!
 }
 
 var unsupportedFlags = []string{
-	"ControlMaster",
+	// "ControlMaster",
 	// "ControlPath",
 	// "ControlPersist",
-	"ExitOnForwardFailure",
+	// "ExitOnForwardFailure",
 	"ForkAfterAuthentication",
-	"ForwardAgent",
+	// "ForwardAgent",
 	"ForwardX11",
 	// "ForwardX11Timeout",
 	"ForwardX11Trusted",
-	"GatewayPorts",
+	// "GatewayPorts",
 	"GSSAPIAuthentication",
 	"GSSAPIDelegateCredentials",
 	"HashKnownHosts",
 	"HostbasedAuthentication",
 	"NoHostAuthenticationForLocalhost",
 	"PermitLocalCommand",
-	"StreamLocalBindUnlink",
+	// "StreamLocalBindUnlink",
 	"Tunnel", // TODO: Must be no!
 	// "TunnelDevice",
 	"UpdateHostKeys", // TODO: May have other values, but must be "no"
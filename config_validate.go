@@ -3,49 +3,111 @@ package sshost
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/tkw1536/sshost/internal/pkg/host"
 	"github.com/tkw1536/sshost/internal/pkg/slices"
 )
 
 // list of algorhtms supported for specific fields
-var sKeyAlgorithms = slices.Combine(knownCertAlgos, knownKeyAlgos, knownKexAlgos)
+var sKeyAlgorithms = slices.Combine(knownCertAlgos, knownSigAlgos)
 var sKexAlgorithms = slices.Combine(knownKexAlgos)
 var sCiphers = slices.Combine(knownCiperNames)
 var sMACs = slices.Combine(knownMACNames)
+var sPubkeyAlgorithms = slices.Combine(knownCertAlgos, knownSigAlgos)
 
 // Validate validates the provided configuration and normalizes it.
 // When validation fails, returns an error of type ErrField; otherwise err is nil.
 //
-// When strict is false, if no algorithms selected within the configuration are supported uses default algorithms instead.
+// When strict is false, if no algorithms selected within the configuration are supported uses default algorithms instead,
+// and a time limit in RekeyLimit is kept, even though it is not enforced.
 // When strict is true, an error is returned instead.
 func (cfg *Config) Validate(strict bool) (err error) {
+	if !cfg.AddKeysToAgent.Mode.Valid() {
+		return NewErrField(nil, "AddKeysToAgent")
+	}
 	if !cfg.AddressFamily.Valid() {
 		return NewErrField(nil, "AddressFamily")
 	}
-	if err := filterSliceField(&cfg.Ciphers, strict, "Ciphers", sCiphers); err != nil {
+	// BindAddress: no validation
+	// BindInterface: no validation
+	// CanonicalDomains: no validation
+	// CanonicalizeFallbackLocal: no validation
+	if !cfg.CanonicalizeHostname.Valid() {
+		return NewErrField(nil, "CanonicalizeHostname")
+	}
+	// CanonicalizeMaxDots: no validation
+	for _, rule := range cfg.CanonicalizePermittedCNAMEs {
+		if _, _, ok := cutRule(rule); !ok && rule != "none" {
+			return NewErrField(nil, "CanonicalizePermittedCNAMEs")
+		}
+	}
+	if err := algorithmsField(&cfg.CASignatureAlgorithms, strict, "CASignatureAlgorithms", defaultCASigAlgos, knownSigAlgos); err != nil {
 		return err
 	}
+	// CertificateFile: no validation
+	if err := algorithmsField(&cfg.Ciphers, strict, "Ciphers", defaultCipherNames, sCiphers); err != nil {
+		return err
+	}
+	// ClearAllForwardings: no validation
 	if cfg.Compression {
-		return NewErrField(nil, "Compression")
+		return NewErrField(ErrCompressionUnsupported, "Compression")
 	}
-	if cfg.ConnectionAttempts != 1 {
+	if cfg.ConnectionAttempts == 0 {
 		return NewErrField(nil, "ConnectionAttempts")
 	}
+	if !cfg.ControlMaster.Valid() {
+		return NewErrField(nil, "ControlMaster")
+	}
+	if cfg.ControlPath == "none" {
+		cfg.ControlPath = ""
+	}
+	// ControlPersist: no validation
 	// ConnectTimeout: no validation
-	if err := filterSliceField(&cfg.HostKeyAlgorithms, strict, "HostKeyAlgorithms", sKeyAlgorithms); err != nil {
+	for _, spec := range cfg.DynamicForward {
+		if _, err := parseDynamicForwarding(spec, cfg.GatewayPorts); err != nil {
+			return NewErrField(err, "DynamicForward")
+		}
+	}
+	// ExitOnForwardFailure: no validation
+	switch strings.ToLower(cfg.ForwardAgent) {
+	case "", "yes", "no":
+		cfg.ForwardAgent = strings.ToLower(cfg.ForwardAgent)
+	default:
+		if !validForwardAgent(cfg.ForwardAgent) {
+			return NewErrField(nil, "ForwardAgent")
+		}
+	}
+	// GatewayPorts: no validation
+	if err := algorithmsField(&cfg.HostKeyAlgorithms, strict, "HostKeyAlgorithms", defaultHostKeyAlgos, sKeyAlgorithms); err != nil {
 		return err
 	}
+	if cfg.HostKeyFingerprint != "" && !validFingerprint(cfg.HostKeyFingerprint) {
+		return NewErrField(nil, "HostKeyFingerprint")
+	}
+	cfg.Hostname = host.Unbracket(cfg.Hostname)
 	if cfg.Hostname == "" {
 		return NewErrField(errEmptyField, "Hostname")
 	}
 	// IdentityAgent: no validation
-	if err := filterSliceField(&cfg.KexAlgorithms, strict, "KexAlgorithms", knownKexAlgos); err != nil {
+	if err := algorithmsField(&cfg.KexAlgorithms, strict, "KexAlgorithms", defaultKexAlgos, sKexAlgorithms); err != nil {
 		return err
 	}
-	if err := filterSliceField(&cfg.MACs, strict, "MACs", sMACs); err != nil {
+	for _, spec := range cfg.LocalForward {
+		if _, err := parseForwarding(spec, cfg.GatewayPorts); err != nil {
+			return NewErrField(err, "LocalForward")
+		}
+	}
+	if err := algorithmsField(&cfg.MACs, strict, "MACs", defaultMACNames, sMACs); err != nil {
 		return err
 	}
+	if cfg.ProxyCommand == "none" {
+		cfg.ProxyCommand = ""
+	}
+	if cfg.ProxyCommand != "" && len(cfg.ProxyJump) > 0 {
+		return NewErrField(errProxyCommandAndJump, "ProxyCommand")
+	}
+	// ProxyUseFdpass: no validation
 	for _, pj := range cfg.ProxyJump {
 		if !host.ValidHost(pj) {
 			return NewErrField(nil, "ProxyJump")
@@ -54,12 +116,28 @@ func (cfg *Config) Validate(strict bool) (err error) {
 	if cfg.Port == 0 || cfg.Port >= 65535 {
 		return NewErrField(nil, "Port")
 	}
-	if cfg.RekeyLimit != "default none" {
-		return NewErrField(nil, "RekeyLimit")
+	if err := algorithmsField(&cfg.PubkeyAcceptedAlgorithms, strict, "PubkeyAcceptedAlgorithms", defaultPubkeyAlgos, sPubkeyAlgorithms); err != nil {
+		return err
+	}
+	if cfg.RekeyLimit.Time != 0 && strict {
+		return NewErrField(ErrRekeyTimeUnsupported, "RekeyLimit")
 	}
 	// ServerAliveCountMax: no validation
-	if cfg.ServerAliveInterval != 0 {
-		return NewErrField(errEmptyField, "ServerAliveInterval")
+	// RevokedHostKeys: no validation
+	for _, spec := range cfg.RemoteForward {
+		if _, err := parseRemoteForwarding(spec); err != nil {
+			return NewErrField(err, "RemoteForward")
+		}
+	}
+	if cfg.ServerAliveInterval < 0 {
+		return NewErrField(nil, "ServerAliveInterval")
+	}
+	if cfg.StreamLocalBindMask > 0777 {
+		return NewErrField(nil, "StreamLocalBindMask")
+	}
+	// StreamLocalBindUnlink: no validation
+	if !cfg.StrictHostKeyChecking.Valid() {
+		return NewErrField(nil, "StrictHostKeyChecking")
 	}
 	if cfg.Username == "" {
 		return NewErrField(errEmptyField, "Username")
@@ -80,6 +158,27 @@ func filterSliceField(slice *[]string, strict bool, field string, valid []string
 	return nil
 }
 
+// algorithmsField applies list modifiers to an algorithm field using applyAlgorithms, and then calls filterSliceField.
+func algorithmsField(slice *[]string, strict bool, field string, defaults []string, valid []string) error {
+	var err error
+	*slice, err = applyAlgorithms(*slice, defaults, valid)
+	if err != nil {
+		if strict {
+			return NewErrField(err, field)
+		}
+		*slice = nil
+		return nil
+	}
+	return filterSliceField(slice, strict, field, valid)
+}
+
+// ErrCompressionUnsupported is returned by Validate when Compression is enabled.
+//
+// The ssh package only implements the "none" compression method.
+// Compression methods such as "zlib@openssh.com" are negotiated and applied inside the encrypted transport,
+// and can not be provided by wrapping the underlying connection.
+var ErrCompressionUnsupported = errors.New("compression is not supported")
+
 // ErrField represents an error for the provided field
 type ErrField struct {
 	error
@@ -97,6 +196,7 @@ func NewErrField(err error, Field string) ErrField {
 
 var errInvalidField = errors.New("field value invalid")
 var errEmptyField = errors.New("field must be non-empty")
+var errProxyCommandAndJump = errors.New("ProxyCommand and ProxyJump cannot be used together")
 
 func (err ErrField) Unwrap() error {
 	return err.error
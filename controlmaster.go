@@ -0,0 +1,273 @@
+package sshost
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+	"github.com/tkw1536/sshost/internal/pkg/mux"
+	"golang.org/x/crypto/ssh"
+)
+
+// ControlMaster specifies if connections are shared with other clients using a ControlPath
+type ControlMaster string
+
+const (
+	// ControlMasterNo uses an existing master, but never becomes one.
+	ControlMasterNo ControlMaster = "no"
+
+	// ControlMasterYes always becomes a master, without using an existing one.
+	ControlMasterYes ControlMaster = "yes"
+
+	// ControlMasterAsk is like ControlMasterYes, but asks the user to confirm each client of the master.
+	ControlMasterAsk ControlMaster = "ask"
+
+	// ControlMasterAuto uses an existing master, or becomes one when none exists.
+	ControlMasterAuto ControlMaster = "auto"
+
+	// ControlMasterAutoAsk is like ControlMasterAuto, but asks the user to confirm each client of the master.
+	ControlMasterAutoAsk ControlMaster = "autoask"
+
+	DefaultControlMaster = ControlMasterNo
+)
+
+// Valid checks if the provided ControlMaster is valid
+func (c ControlMaster) Valid() bool {
+	switch c {
+	case ControlMasterNo, ControlMasterYes, ControlMasterAsk, ControlMasterAuto, ControlMasterAutoAsk:
+		return true
+	default:
+		return false
+	}
+}
+
+// Reuses checks if an existing master is used
+func (c ControlMaster) Reuses() bool {
+	return c == ControlMasterNo || c == ControlMasterAuto || c == ControlMasterAutoAsk
+}
+
+// Listens checks if new connections become a master
+func (c ControlMaster) Listens() bool {
+	return c != ControlMasterNo
+}
+
+// Asks checks if clients of the master need to be confirmed by the user
+func (c ControlMaster) Asks() bool {
+	return c == ControlMasterAsk || c == ControlMasterAutoAsk
+}
+
+// ControlPersistForever is the ControlPersist value that keeps a master open until it is terminated explicitly.
+const ControlPersistForever = time.Duration(math.MaxInt64)
+
+var controlPathFlags = expand.Flags{
+	Environment: true,
+	Tilde:       true,
+	Tokens:      "%CdhijkLlnpru",
+}
+
+// ControlPath returns the expanded ControlPath of this profile.
+// When no ControlPath is used, returns the empty string.
+func (profile *Profile) ControlPath() (string, error) {
+	path := profile.config.ControlPath
+	if path == "" || path == "none" {
+		return "", nil
+	}
+
+	ex := profile.expander()
+	return ex.Expand(path, controlPathFlags)
+}
+
+// controlClient returns a client using an existing master for this profile.
+// When no master can be used, ok is false.
+func (profile *Profile) controlClient() (client *ssh.Client, stack *closer.Stack, ok bool) {
+	cfg, err := profile.GetConfig()
+	if err != nil || !cfg.ControlMaster.Reuses() {
+		return nil, nil, false
+	}
+
+	path, err := profile.ControlPath()
+	if err != nil || path == "" {
+		return nil, nil, false
+	}
+
+	conn, err := mux.Dial(path, cfg.Username)
+	if err != nil {
+		// remove stale sockets of masters that went away
+		if errors.Is(err, syscall.ECONNREFUSED) && cfg.ControlMaster.Listens() {
+			os.Remove(path)
+		}
+		return nil, nil, false
+	}
+
+	client = mux.NewClient(conn)
+	return client, closer.NewStack(client), true
+}
+
+// startControlMaster shares client with other clients using the ControlPath of this profile.
+// stack must close the connection of client.
+//
+// Returns the stack to be closed by the caller.
+// When the profile does not become a master, this is stack itself.
+// Otherwise closing it closes the connection once no other clients use it, and ControlPersist has expired.
+func (profile *Profile) startControlMaster(client *ssh.Client, stack *closer.Stack) *closer.Stack {
+	cfg, err := profile.GetConfig()
+	if err != nil || !cfg.ControlMaster.Listens() {
+		return stack
+	}
+
+	path, err := profile.ControlPath()
+	if err != nil || path == "" {
+		return stack
+	}
+
+	// when the socket already exists, another master is running
+	listener, err := listenControl(path)
+	if err != nil {
+		return stack
+	}
+
+	master := &controlMaster{
+		path:    path,
+		persist: cfg.ControlPersist,
+		stack:   stack,
+		primary: true,
+	}
+	master.server = &mux.Server{
+		Client:    client,
+		Terminate: master.close,
+		Active:    master.active,
+	}
+	if cfg.ControlMaster.Asks() {
+		master.server.Confirm = func(description string) bool {
+			ok, err := profile.env.Auth.askControl(cfg.Hostname, description)
+			return err == nil && ok
+		}
+	}
+
+	go master.server.Serve(listener)
+	go func() {
+		client.Wait()
+		master.close()
+	}()
+
+	return closer.NewStack(closer.NewCloser(master.release))
+}
+
+// listenControl listens on the unix socket at path.
+// It fails if path already exists.
+func listenControl(path string) (net.Listener, error) {
+	// listen on a temporary path, and then link it into place.
+	// This prevents races between multiple masters.
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+	temp := path + "." + hex.EncodeToString(suffix[:])
+
+	listener, err := net.Listen("unix", temp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp)
+
+	if err := os.Chmod(temp, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Link(temp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	// the socket is removed by the master itself
+	if unix, ok := listener.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+	}
+	return listener, nil
+}
+
+// controlMaster is a master sharing a connection with other clients
+type controlMaster struct {
+	path    string
+	persist time.Duration
+	server  *mux.Server
+	stack   *closer.Stack // closes the shared connection
+
+	m       sync.Mutex
+	primary bool // the client that created the master still uses the connection
+	clients int  // number of connected clients
+	timer   *time.Timer
+	closed  bool
+}
+
+// release releases the connection of the client that created the master
+func (master *controlMaster) release() error {
+	master.m.Lock()
+	defer master.m.Unlock()
+
+	master.primary = false
+	master.update()
+	return nil
+}
+
+// active is called when the number of connected clients changes
+func (master *controlMaster) active(clients int) {
+	master.m.Lock()
+	defer master.m.Unlock()
+
+	master.clients = clients
+	master.update()
+}
+
+// update closes the master once it is unused for ControlPersist.
+// master.m must be held.
+func (master *controlMaster) update() {
+	if master.timer != nil {
+		master.timer.Stop()
+		master.timer = nil
+	}
+
+	if master.closed || master.primary || master.clients > 0 || master.persist == ControlPersistForever {
+		return
+	}
+	master.timer = time.AfterFunc(master.persist, master.close)
+}
+
+// close stops the master and closes the shared connection
+func (master *controlMaster) close() {
+	master.m.Lock()
+	if master.closed {
+		master.m.Unlock()
+		return
+	}
+	master.closed = true
+	if master.timer != nil {
+		master.timer.Stop()
+	}
+	master.m.Unlock()
+
+	master.server.Close()
+	os.Remove(master.path)
+	master.stack.Close()
+}
+
+// askControl asks the user if a client may use the shared connection to hostname
+func (m AuthEnv) askControl(hostname string, description string) (bool, error) {
+	m.print(fmt.Sprintf("Allow shared connection to %q (%s)? ", hostname, description), false)
+
+	answer, err := m.readOpen()
+	if err != nil {
+		return false, err
+	}
+	return strings.ToLower(answer) == "yes", nil
+}
@@ -2,11 +2,14 @@ package sshost
 
 import (
 	"context"
+	"log"
+	"time"
 
 	"github.com/tkw1536/sshost/internal/pkg/closer"
 	"github.com/tkw1536/sshost/internal/pkg/host"
 	"github.com/tkw1536/sshost/internal/pkg/source"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // Environment represents a system environment to derive a Config from
@@ -21,8 +24,41 @@ type Environment struct {
 	Defaults Defaults
 	Auth     AuthEnv
 
+	// Local holds information about the local user and machine
+	Local Local
+
+	// Resolver is used to canonicalize hostnames.
+	// When nil, uses net.DefaultResolver.
+	Resolver Resolver
+
+	// Backoff returns how long to wait after a failed connection attempt before the next one.
+	// attempt is the number of the failed attempt, starting at 1.
+	// When nil, uses DefaultBackoff.
+	Backoff func(attempt int) time.Duration
+
+	// ForwardKeyring, when non-nil, is forwarded to hosts with ForwardAgent enabled, instead of the agent configured by ForwardAgent.
+	// Use agent.NewKeyring to forward only a restricted set of keys.
+	ForwardKeyring agent.Agent
+
+	// Passphrases caches passphrases of encrypted private keys, for the lifetime of the environment.
+	// NewDefaultEnvironment uses NewPassphraseCache.
+	// When nil, a new cache is created when it is first needed.
+	// Because NewClient uses a copy of the environment, such a cache is only shared between the hosts of a single connection, including ProxyJump hosts.
+	Passphrases *PassphraseCache
+
+	// Pool, when non-nil, shares clients for ProxyJump hosts between connections.
+	Pool *ClientPool
+
+	// RetryProxyJump indicates if connections to ProxyJump hosts are retried according to their ConnectionAttempts.
+	// When false, only the connection to the final host is retried.
+	RetryProxyJump bool
+
 	// Variables contains values of system environment variables
 	Variables func(name string) string
+
+	// Warn is called with errors that do not prevent a connection, such as forwardings that failed to start.
+	// When nil, warnings are written using the log package.
+	Warn func(err error)
 }
 
 // getenv returns ctx.Variables, protected against Variables being nil
@@ -33,21 +69,93 @@ func (env Environment) getenv(name string) string {
 	return env.Variables(name)
 }
 
+// warn reports err using env.Warn, or the log package when it is nil
+func (env Environment) warn(err error) {
+	if env.Warn == nil {
+		log.Printf("warning: %s", err)
+		return
+	}
+	env.Warn(err)
+}
+
 // NewClient creates a new client.
 // See also DialContext and connect.
 //
+// When ServerAliveInterval is set, the server is periodically sent keepalive requests.
+// When it does not reply to ServerAliveCountMax requests, the returned stack is closed and Wait on the client returns an ErrServerAliveTimeout.
+// A ServerAliveCountMax of zero keeps sending requests, but never closes the connection.
+//
+// When a ControlPath is configured, an existing master is used instead of establishing a new connection.
+// Depending on ControlMaster, the new connection becomes a master itself.
+// Closing the returned stack then closes the connection only once no other clients use it, and ControlPersist has expired.
+//
+// Unless ClearAllForwardings is set, the configured LocalForward, RemoteForward and DynamicForward forwardings are started.
+// They are stopped when the returned stack is closed.
+// When ExitOnForwardFailure is set and a forwarding can not be started, returns an error of type ErrForwardFailed.
+// ProxyJump hosts never start forwardings.
+//
+// When ForwardAgent is enabled, agent channels opened by the server are forwarded to the agent.
+// Sessions must request agent forwarding, see Profile.NewSession.
+//
 // The provided context is only used during the dialing phase, if the context is canceled after the context phase, it has no effect.
 func (env Environment) NewClient(proxy *ssh.Client, alias string, ctx context.Context) (*ssh.Client, *closer.Stack, error) {
-	profile, err := env.NewProfile(alias)
+	// share passphrases between all hosts of the connection
+	env.passphrases()
+
+	profile, err := env.NewProfileContext(alias, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, closers, err := profile.newClient(proxy, ctx, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return profile.forward(client, closers)
+}
+
+// newClient implements NewClient.
+// retry indicates if the connection should be retried according to ConnectionAttempts.
+func (env Environment) newClient(proxy *ssh.Client, alias string, ctx context.Context, retry bool) (*ssh.Client, *closer.Stack, error) {
+	profile, err := env.NewProfileContext(alias, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return profile.newClient(proxy, ctx, retry)
+}
+
+// jumpClient creates a new client for the ProxyJump host alias, using proxy.
+// When the environment has a Pool, clients are shared.
+func (env Environment) jumpClient(proxy *ssh.Client, alias string, ctx context.Context) (*ssh.Client, *closer.Stack, error) {
+	if env.Pool == nil {
+		return env.newClient(proxy, alias, ctx, env.RetryProxyJump)
+	}
+
+	profile, err := env.NewProfileContext(alias, ctx)
 	if err != nil {
 		return nil, nil, err
 	}
+	return env.Pool.client(profile, proxy, ctx, env.RetryProxyJump)
+}
 
-	conn, closers, err := profile.Dial(proxy, ctx)
+// newClient creates a new client for this profile, see Environment.NewClient.
+func (profile *Profile) newClient(proxy *ssh.Client, ctx context.Context, retry bool) (*ssh.Client, *closer.Stack, error) {
+	// use an existing master, when there is one
+	if client, closers, ok := profile.controlClient(); ok {
+		return client, closers, nil
+	}
+
+	conn, closers, err := profile.dial(proxy, ctx, retry)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// monitor the connection when ServerAliveInterval is set
+	var alive *serverAlive
+	if profile.config.ServerAliveInterval > 0 {
+		alive = newServerAlive(conn, profile.config)
+		conn = alive
+	}
+
 	client, err := profile.Connect(conn)
 	if err != nil {
 		defer closers.Close()
@@ -55,12 +163,36 @@ func (env Environment) NewClient(proxy *ssh.Client, alias string, ctx context.Co
 	}
 
 	closers.Push(client)
-	return client, closers, err
+	if alive != nil {
+		go alive.run(client, closers)
+	}
+	return client, profile.startControlMaster(client, closers), nil
+}
+
+// forward starts agent forwarding and the configured forwardings for client, and pushes them onto closers.
+// When they can not be started, closes closers.
+func (profile *Profile) forward(client *ssh.Client, closers *closer.Stack) (*ssh.Client, *closer.Stack, error) {
+	if err := profile.forwardAgent(client); err != nil {
+		defer closers.Close()
+		return nil, nil, err
+	}
+	if err := profile.startForwarders(client, closers); err != nil {
+		defer closers.Close()
+		return nil, nil, err
+	}
+	return client, closers, nil
 }
 
-// NewProfile gets a new profile for the environment
+// NewProfile gets a new profile for the environment.
+// It is like NewProfileContext, using context.Background().
 func (env *Environment) NewProfile(alias string) (profile *Profile, err error) {
-	cfg, err := env.NewConfig(alias)
+	return env.NewProfileContext(alias, context.Background())
+}
+
+// NewProfileContext gets a new profile for the environment.
+// ctx bounds the commands run by 'Match exec' criteria and hostname canonicalization.
+func (env *Environment) NewProfileContext(alias string, ctx context.Context) (profile *Profile, err error) {
+	cfg, err := env.NewConfigContext(alias, ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -71,10 +203,19 @@ func (env *Environment) NewProfile(alias string) (profile *Profile, err error) {
 }
 
 // NewConfig creates a new configuration for the provided alias.
+// It is like NewConfigContext, using context.Background().
+func (env Environment) NewConfig(alias string) (Config, error) {
+	return env.NewConfigContext(alias, context.Background())
+}
+
+// NewConfigContext creates a new configuration for the provided alias.
 //
 // alias may be a simple hostname or a more complex ssh uri.
-// See config.ParseHost for details.
-func (env Environment) NewConfig(alias string) (Config, error) {
+// See host.ParseHost for details.
+//
+// ctx bounds the commands run by 'Match exec' criteria and hostname canonicalization.
+// When it is cancelled, running commands are killed and their criteria do not match.
+func (env Environment) NewConfigContext(alias string, ctx context.Context) (Config, error) {
 	// Parse the hostname
 	h, err := host.ParseHost(alias)
 	if err != nil {
@@ -82,13 +223,25 @@ func (env Environment) NewConfig(alias string) (Config, error) {
 	}
 
 	// create a new configuration
-	src := env.Source.Alias(h.Host)
+	src, canonical, err := env.matchSource(h, ctx)
+	if err != nil {
+		return Config{}, err
+	}
 	cfg, err := NewConfig(src, h, env.Defaults)
 	if err != nil {
 		return cfg, err
 	}
 
-	// TODO: Expand configuration!
+	// use the canonical hostname, or expand the configured one.
+	// other settings are expanded by the profile when accessed.
+	if canonical != "" {
+		cfg.Hostname = canonical
+	} else {
+		cfg.Hostname, err = expandHostname(cfg.Hostname, h.Host)
+		if err != nil {
+			return cfg, err
+		}
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,59 @@
+package sshost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tkw1536/sshost/internal/pkg/source"
+)
+
+// newTestEnvironment returns an environment reading the configuration from config.
+//
+// The local user "user" has the home directory /home/user.
+// Only the HOME environment variable is set.
+// Warnings are written to the test log.
+func newTestEnvironment(t *testing.T, config string) *Environment {
+	t.Helper()
+
+	src, err := source.Parse(strings.NewReader(config), "config", "/home/user/.ssh", "/home/user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Environment{
+		Source:      src,
+		Defaults:    Defaults{Username: "user"},
+		Local:       Local{Home: "/home/user", Username: "user"},
+		Passphrases: NewPassphraseCache(),
+		Variables: func(name string) string {
+			if name == "HOME" {
+				return "/home/user"
+			}
+			return ""
+		},
+		Warn: func(err error) {
+			t.Logf("warning: %s", err)
+		},
+	}
+}
+
+// newTestConfig returns the configuration for example.com, read from config
+func newTestConfig(t *testing.T, config string) Config {
+	t.Helper()
+
+	cfg, err := newTestEnvironment(t, config).NewConfig("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newTestProfile returns the profile for example.com, read from config
+func newTestProfile(t *testing.T, config string) *Profile {
+	t.Helper()
+
+	profile, err := newTestEnvironment(t, config).NewProfile("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profile
+}
@@ -0,0 +1,76 @@
+package sshost
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fingerprint is a parsed host key fingerprint
+type fingerprint struct {
+	// Algorithm is the expected key algorithm, or empty when any algorithm is allowed.
+	Algorithm string
+
+	// Value is the fingerprint in the format returned by ssh.FingerprintSHA256,
+	// or ssh.FingerprintLegacyMD5 with an additional "MD5:" prefix.
+	Value string
+}
+
+// parseFingerprint parses a fingerprint.
+//
+// value may be of the form "SHA256:<base64>", "MD5:<hex>" or "<algorithm>-<hex>".
+// The latter is used by ssh uris, and uses '-' as a separator for the individual md5 bytes.
+func parseFingerprint(value string) (fp fingerprint, ok bool) {
+	switch {
+	case strings.HasPrefix(value, "SHA256:"):
+		fp.Value = strings.TrimRight(value, "=")
+		return fp, len(fp.Value) > len("SHA256:")
+	case strings.HasPrefix(value, "MD5:"):
+		fp.Value = "MD5:" + strings.ToLower(value[len("MD5:"):])
+		return fp, validMD5(strings.Split(value[len("MD5:"):], ":"))
+	}
+
+	parts := strings.Split(value, "-")
+	if len(parts) <= md5Size || !validMD5(parts[len(parts)-md5Size:]) {
+		return fp, false
+	}
+	fp.Algorithm = strings.Join(parts[:len(parts)-md5Size], "-")
+	fp.Value = "MD5:" + strings.ToLower(strings.Join(parts[len(parts)-md5Size:], ":"))
+	return fp, true
+}
+
+const md5Size = 16
+
+// validMD5 checks that parts consists of the hex encoded bytes of an md5 hash
+func validMD5(parts []string) bool {
+	if len(parts) != md5Size {
+		return false
+	}
+	for _, part := range parts {
+		if len(part) != 2 {
+			return false
+		}
+		if _, err := hex.DecodeString(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// validFingerprint checks if value is a valid fingerprint
+func validFingerprint(value string) bool {
+	_, ok := parseFingerprint(value)
+	return ok
+}
+
+// Matches checks if key matches this fingerprint
+func (fp fingerprint) Matches(key ssh.PublicKey) bool {
+	if fp.Algorithm != "" && fp.Algorithm != key.Type() {
+		return false
+	}
+	if strings.HasPrefix(fp.Value, "MD5:") {
+		return fp.Value == "MD5:"+ssh.FingerprintLegacyMD5(key)
+	}
+	return fp.Value == ssh.FingerprintSHA256(key)
+}
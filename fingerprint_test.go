@@ -0,0 +1,66 @@
+package sshost
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_parseFingerprint(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	key, err := ssh.NewPublicKey(ed25519.NewKeyFromSeed(seed).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha256 := ssh.FingerprintSHA256(key)
+	md5 := ssh.FingerprintLegacyMD5(key)
+	uri := strings.ReplaceAll(md5, ":", "-")
+
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(other.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		value     string
+		wantOK    bool
+		wantMatch bool
+	}{
+		{"sha256", sha256, true, true},
+		{"sha256 with padding", sha256 + "=", true, true},
+		{"sha256 empty", "SHA256:", false, false},
+		{"md5", "MD5:" + md5, true, true},
+		{"md5 uppercase", "MD5:" + strings.ToUpper(md5), true, true},
+		{"md5 short", "MD5:" + md5[3:], false, false},
+		{"uri", "ssh-ed25519-" + uri, true, true},
+		{"uri uppercase", "ssh-ed25519-" + strings.ToUpper(uri), true, true},
+		{"uri wrong algorithm", "ssh-rsa-" + uri, true, false},
+		{"uri without algorithm", uri, false, false},
+		{"invalid", "nonsense", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, ok := parseFingerprint(tt.value)
+			if ok != tt.wantOK {
+				t.Fatalf("parseFingerprint(%q) ok = %v, want %v", tt.value, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got := fp.Matches(key); got != tt.wantMatch {
+				t.Errorf("parseFingerprint(%q).Matches() = %v, want %v", tt.value, got, tt.wantMatch)
+			}
+			if fp.Matches(otherKey) {
+				t.Errorf("parseFingerprint(%q).Matches() matched an unrelated key", tt.value)
+			}
+		})
+	}
+}
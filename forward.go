@@ -0,0 +1,559 @@
+package sshost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+	"github.com/tkw1536/sshost/internal/pkg/socks"
+	"golang.org/x/crypto/ssh"
+)
+
+// ForwardAddr is an address used by a forwarding.
+// It is either a TCP address or the path to a unix socket.
+type ForwardAddr struct {
+	Network string // "tcp" or "unix"
+	Address string // "host:port" or a path
+}
+
+func (addr ForwardAddr) String() string {
+	return addr.Address
+}
+
+// Forwarding forwards connections accepted on a Listen address to a Connect address.
+//
+// A dynamic forwarding has a zero Connect address.
+// Each accepted connection then is a SOCKS client, and is forwarded to the destination it requests.
+type Forwarding struct {
+	Listen  ForwardAddr
+	Connect ForwardAddr
+}
+
+// Dynamic checks if this is a dynamic forwarding
+func (fwd Forwarding) Dynamic() bool {
+	return fwd.Connect == ForwardAddr{}
+}
+
+var errInvalidForward = errors.New("invalid forwarding specification")
+
+// parseForwarding parses a LocalForward or RemoteForward specification of the form "listen connect".
+// When gatewayPorts is false, TCP listen addresses without a host only listen on localhost.
+func parseForwarding(spec string, gatewayPorts bool) (fwd Forwarding, err error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return fwd, errInvalidForward
+	}
+
+	fwd.Listen, err = parseListenAddr(fields[0], gatewayPorts)
+	if err != nil {
+		return fwd, err
+	}
+	fwd.Connect, err = parseForwardAddr(fields[1], false)
+	if err != nil {
+		return fwd, err
+	}
+	return fwd, nil
+}
+
+// parseRemoteForwarding parses a RemoteForward specification.
+// It is either of the form "listen connect", or only "listen" for a dynamic forwarding.
+func parseRemoteForwarding(spec string) (Forwarding, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 1 {
+		return parseForwarding(spec, false)
+	}
+
+	listen, err := parseListenAddr(fields[0], false)
+	if err != nil {
+		return Forwarding{}, err
+	}
+	return Forwarding{Listen: listen}, nil
+}
+
+// parseDynamicForwarding parses a DynamicForward specification of the form "[bind_address:]port"
+func parseDynamicForwarding(spec string, gatewayPorts bool) (Forwarding, error) {
+	listen, err := parseListenAddr(strings.TrimSpace(spec), gatewayPorts)
+	if err != nil {
+		return Forwarding{}, err
+	}
+	if listen.Network != "tcp" {
+		return Forwarding{}, errInvalidForward
+	}
+	return Forwarding{Listen: listen}, nil
+}
+
+// parseListenAddr parses the listen address of a forwarding specification.
+// It is either "[bind_address:]port" or a path to a unix socket.
+//
+// An empty bind address or "*" listens on all interfaces.
+// When no bind address is given, listens on localhost unless gatewayPorts is true.
+func parseListenAddr(spec string, gatewayPorts bool) (ForwardAddr, error) {
+	addr, err := parseForwardAddr(spec, true)
+	if err != nil || addr.Network != "tcp" {
+		return addr, err
+	}
+
+	host, port, _ := net.SplitHostPort(addr.Address)
+	switch {
+	case host == "*":
+		host = ""
+	case host == "" && !strings.HasPrefix(spec, ":") && !gatewayPorts:
+		host = "localhost"
+	}
+	addr.Address = net.JoinHostPort(host, port)
+	return addr, nil
+}
+
+// parseForwardAddr parses "host:port", "host/port", "[host]:port" or a path to a unix socket.
+// When portOnly is true, the host may be omitted.
+func parseForwardAddr(spec string, portOnly bool) (ForwardAddr, error) {
+	if strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "~") {
+		return ForwardAddr{Network: "unix", Address: spec}, nil
+	}
+
+	var host, port string
+	switch {
+	case strings.HasPrefix(spec, "["):
+		end := strings.Index(spec, "]")
+		if end < 0 || !strings.HasPrefix(spec[end+1:], ":") && !strings.HasPrefix(spec[end+1:], "/") {
+			return ForwardAddr{}, errInvalidForward
+		}
+		host, port = spec[1:end], spec[end+2:]
+	case strings.ContainsAny(spec, ":/"):
+		index := strings.LastIndexAny(spec, ":/")
+		host, port = spec[:index], spec[index+1:]
+	case portOnly:
+		port = spec
+	default:
+		return ForwardAddr{}, errInvalidForward
+	}
+
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return ForwardAddr{}, errInvalidForward
+	}
+	if host == "" && !portOnly {
+		return ForwardAddr{}, errInvalidForward
+	}
+	return ForwardAddr{Network: "tcp", Address: net.JoinHostPort(host, port)}, nil
+}
+
+var forwardPathFlags = expand.Flags{
+	Environment: true,
+	Tilde:       true,
+	Tokens:      "%CdhijkLlnpru",
+}
+
+// expandForwardAddr expands the path of a unix socket address.
+// local indicates if the socket is on the local machine, only then the tilde is expanded.
+func (profile *Profile) expandForwardAddr(addr ForwardAddr, local bool) (ForwardAddr, error) {
+	if addr.Network != "unix" {
+		return addr, nil
+	}
+
+	flags := forwardPathFlags
+	flags.Tilde = local
+
+	ex := profile.expander()
+	path, err := ex.Expand(addr.Address, flags)
+	if err != nil {
+		return addr, err
+	}
+	addr.Address = path
+	return addr, nil
+}
+
+// LocalForwards returns the LocalForward settings of this profile.
+// Paths of unix sockets are expanded.
+func (profile *Profile) LocalForwards() ([]Forwarding, error) {
+	cfg, err := profile.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	forwards := make([]Forwarding, 0, len(cfg.LocalForward))
+	for _, spec := range cfg.LocalForward {
+		fwd, err := parseForwarding(spec, cfg.GatewayPorts)
+		if err != nil {
+			return nil, NewErrField(err, "LocalForward")
+		}
+		if fwd.Listen, err = profile.expandForwardAddr(fwd.Listen, true); err != nil {
+			return nil, err
+		}
+		if fwd.Connect, err = profile.expandForwardAddr(fwd.Connect, false); err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, fwd)
+	}
+	return forwards, nil
+}
+
+// RemoteForwards returns the RemoteForward settings of this profile.
+// Paths of unix sockets are expanded.
+func (profile *Profile) RemoteForwards() ([]Forwarding, error) {
+	cfg, err := profile.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	forwards := make([]Forwarding, 0, len(cfg.RemoteForward))
+	for _, spec := range cfg.RemoteForward {
+		fwd, err := parseRemoteForwarding(spec)
+		if err != nil {
+			return nil, NewErrField(err, "RemoteForward")
+		}
+		if fwd.Listen, err = profile.expandForwardAddr(fwd.Listen, false); err != nil {
+			return nil, err
+		}
+		if fwd.Connect, err = profile.expandForwardAddr(fwd.Connect, true); err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, fwd)
+	}
+	return forwards, nil
+}
+
+// DynamicForwards returns the DynamicForward settings of this profile.
+// See also DynamicForward.
+func (profile *Profile) DynamicForwards() ([]Forwarding, error) {
+	cfg, err := profile.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	forwards := make([]Forwarding, 0, len(cfg.DynamicForward))
+	for _, spec := range cfg.DynamicForward {
+		fwd, err := parseDynamicForwarding(spec, cfg.GatewayPorts)
+		if err != nil {
+			return nil, NewErrField(err, "DynamicForward")
+		}
+		forwards = append(forwards, fwd)
+	}
+	return forwards, nil
+}
+
+// LocalForward listens on the local address listen, and forwards each accepted connection to connect using client.
+// Unix sockets are created according to StreamLocalBindMask and StreamLocalBindUnlink.
+//
+// Forwarding stops when ctx is cancelled, or the returned closer is closed.
+// Closing the returned closer also closes all forwarded connections.
+func (profile *Profile) LocalForward(ctx context.Context, client *ssh.Client, listen, connect ForwardAddr) (closer.Closer, error) {
+	listener, err := profile.listenLocal(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	return startForwarder(ctx, listener, func(net.Conn) (net.Conn, error) {
+		return client.Dial(connect.Network, connect.Address)
+	}), nil
+}
+
+// DynamicForward listens on the local address listen, and runs a SOCKS4, SOCKS4a and SOCKS5 proxy on it.
+// Each accepted connection is forwarded to the destination it requests using client.
+// Domain names are resolved by the server.
+//
+// Forwarding stops when ctx is cancelled, or the returned closer is closed.
+// Closing the returned closer also closes all forwarded connections.
+func (profile *Profile) DynamicForward(ctx context.Context, client *ssh.Client, listen ForwardAddr) (closer.Closer, error) {
+	listener, err := profile.listenLocal(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	return startForwarder(ctx, listener, func(conn net.Conn) (net.Conn, error) {
+		return socks.Handshake(conn, client.Dial)
+	}), nil
+}
+
+// dynamicForward is like DynamicForward, but has the same signature as LocalForward and RemoteForward.
+// connect is ignored.
+func (profile *Profile) dynamicForward(ctx context.Context, client *ssh.Client, listen, connect ForwardAddr) (closer.Closer, error) {
+	return profile.DynamicForward(ctx, client, listen)
+}
+
+// RemoteForward requests the server to listen on the remote address listen, and forwards each accepted connection to the local address connect.
+// When connect is the zero address, accepted connections are handled by a SOCKS proxy instead, see Forwarding.
+//
+// Hostnames of TCP listen addresses are resolved locally.
+// An empty host listens on all interfaces, subject to the GatewayPorts setting of the server.
+//
+// Forwarding stops when ctx is cancelled, or the returned closer is closed.
+// Closing the returned closer also closes all forwarded connections.
+func (profile *Profile) RemoteForward(ctx context.Context, client *ssh.Client, listen, connect ForwardAddr) (closer.Closer, error) {
+	listener, err := listenRemote(client, listen)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	if (connect == ForwardAddr{}) {
+		return startForwarder(ctx, listener, func(conn net.Conn) (net.Conn, error) {
+			return socks.Handshake(conn, dialer.Dial)
+		}), nil
+	}
+	return startForwarder(ctx, listener, func(net.Conn) (net.Conn, error) {
+		return dialer.DialContext(ctx, connect.Network, connect.Address)
+	}), nil
+}
+
+// listenRemote requests client to listen on the remote address addr
+func listenRemote(client *ssh.Client, addr ForwardAddr) (net.Listener, error) {
+	if addr.Network == "unix" {
+		return client.ListenUnix(addr.Address)
+	}
+
+	host, port, err := net.SplitHostPort(addr.Address)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	return client.Listen(addr.Network, net.JoinHostPort(host, port))
+}
+
+// listenLocal listens on the local address addr
+func (profile *Profile) listenLocal(addr ForwardAddr) (net.Listener, error) {
+	if addr.Network != "unix" {
+		return net.Listen(addr.Network, addr.Address)
+	}
+
+	cfg, err := profile.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.StreamLocalBindUnlink {
+		if err := os.Remove(addr.Address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return listenUnix(addr.Address, cfg.StreamLocalBindMask)
+}
+
+// startForwarders starts all forwardings configured for this profile using client.
+// Closers for the forwardings are pushed onto stack.
+//
+// When a forwarding fails to start, the error is of type ErrForwardFailed.
+// It is returned when ExitOnForwardFailure is set, and otherwise reported using the Warn function of the environment.
+func (profile *Profile) startForwarders(client *ssh.Client, stack *closer.Stack) error {
+	cfg, err := profile.GetConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.ClearAllForwardings {
+		return nil
+	}
+
+	locals, err := profile.LocalForwards()
+	if err != nil {
+		return err
+	}
+	remotes, err := profile.RemoteForwards()
+	if err != nil {
+		return err
+	}
+	dynamics, err := profile.DynamicForwards()
+	if err != nil {
+		return err
+	}
+
+	start := func(fwd Forwarding, forward func(context.Context, *ssh.Client, ForwardAddr, ForwardAddr) (closer.Closer, error)) error {
+		c, err := forward(context.Background(), client, fwd.Listen, fwd.Connect)
+		if err != nil {
+			err = ErrForwardFailed{Forwarding: fwd, Err: err}
+			if cfg.ExitOnForwardFailure {
+				return err
+			}
+			profile.env.warn(err)
+			return nil
+		}
+		stack.Push(c)
+		return nil
+	}
+
+	for _, fwd := range locals {
+		if err := start(fwd, profile.LocalForward); err != nil {
+			return err
+		}
+	}
+	for _, fwd := range remotes {
+		if err := start(fwd, profile.RemoteForward); err != nil {
+			return err
+		}
+	}
+	for _, fwd := range dynamics {
+		if err := start(fwd, profile.dynamicForward); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwarder accepts connections on a listener, and forwards them
+type forwarder struct {
+	listener net.Listener
+	dial     func(conn net.Conn) (net.Conn, error)
+
+	m      sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+
+	done chan struct{}
+}
+
+// startForwarder starts forwarding connections accepted by listener to the connections returned by dial.
+// dial receives the accepted connection, and may read from it.
+// When dial returns a nil connection without an error, the accepted connection is closed.
+//
+// Forwarding stops when ctx is cancelled, or the returned forwarder is closed.
+func startForwarder(ctx context.Context, listener net.Listener, dial func(conn net.Conn) (net.Conn, error)) *forwarder {
+	fwd := &forwarder{
+		listener: listener,
+		dial:     dial,
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go fwd.serve()
+	go func() {
+		select {
+		case <-ctx.Done():
+			fwd.Close()
+		case <-fwd.done:
+		}
+	}()
+	return fwd
+}
+
+// Addr returns the address the forwarder is listening on
+func (fwd *forwarder) Addr() net.Addr {
+	return fwd.listener.Addr()
+}
+
+func (fwd *forwarder) serve() {
+	defer fwd.Close()
+
+	for {
+		conn, err := fwd.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !fwd.track(conn) {
+			conn.Close()
+			return
+		}
+		go fwd.handle(conn)
+	}
+}
+
+// handle forwards a single accepted connection
+func (fwd *forwarder) handle(conn net.Conn) {
+	defer fwd.untrack(conn)
+	defer conn.Close()
+
+	remote, err := fwd.dial(conn)
+	if err != nil || remote == nil {
+		return
+	}
+	if !fwd.track(remote) {
+		remote.Close()
+		return
+	}
+	defer fwd.untrack(remote)
+	defer remote.Close()
+
+	pipe(conn, remote)
+}
+
+// track adds conn to the set of active connections.
+// When the forwarder is already closed, returns false.
+func (fwd *forwarder) track(conn net.Conn) bool {
+	fwd.m.Lock()
+	defer fwd.m.Unlock()
+
+	if fwd.closed {
+		return false
+	}
+	fwd.conns[conn] = struct{}{}
+	return true
+}
+
+func (fwd *forwarder) untrack(conn net.Conn) {
+	fwd.m.Lock()
+	defer fwd.m.Unlock()
+
+	delete(fwd.conns, conn)
+}
+
+// Close stops accepting connections, and closes all forwarded connections
+func (fwd *forwarder) Close() error {
+	fwd.m.Lock()
+	if fwd.closed {
+		fwd.m.Unlock()
+		return nil
+	}
+	fwd.closed = true
+	conns := fwd.conns
+	fwd.conns = nil
+	close(fwd.done)
+	fwd.m.Unlock()
+
+	err := fwd.listener.Close()
+	for conn := range conns {
+		conn.Close()
+	}
+	return err
+}
+
+// pipe copies data between a and b until both directions are done
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(a, b)
+		closeWrite(a)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(b, a)
+		closeWrite(b)
+	}()
+	wg.Wait()
+}
+
+// closeWrite closes the writing side of conn, if supported.
+// Otherwise closes conn entirely.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// ErrForwardFailed is returned when a forwarding could not be started
+type ErrForwardFailed struct {
+	Forwarding Forwarding
+	Err        error
+}
+
+func (err ErrForwardFailed) Error() string {
+	if err.Forwarding.Dynamic() {
+		return fmt.Sprintf("dynamic forwarding on %s failed: %s", err.Forwarding.Listen, err.Err)
+	}
+	return fmt.Sprintf("forwarding from %s to %s failed: %s", err.Forwarding.Listen, err.Forwarding.Connect, err.Err)
+}
+
+func (err ErrForwardFailed) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,61 @@
+//go:build unix
+
+package sshost
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenUnix listens on a unix socket at path, created with the permissions 0666 masked by mask.
+//
+// The socket is created inside a private temporary directory, and only linked into place once it has the right permissions.
+// Changing the permissions at path afterwards would leave a window in which the socket can be accessed by anyone permitted by the umask.
+func listenUnix(path string, mask uint64) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sshost")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	temp := filepath.Join(dir, "s")
+	listener, err := net.Listen("unix", temp)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(temp, os.FileMode(0666&^mask)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Link(temp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	// the temporary path is removed above, the final one when the listener is closed
+	if unix, ok := listener.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+	}
+	return &unixListener{Listener: listener, path: path}, nil
+}
+
+// unixListener is a listener on a unix socket that was linked to path
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (listener *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: listener.path, Net: "unix"}
+}
+
+func (listener *unixListener) Close() error {
+	err := listener.Listener.Close()
+	if rerr := os.Remove(listener.path); err == nil && rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package sshost
+
+import "net"
+
+// listenUnix listens on a unix socket at path.
+// This platform does not support socket permissions, so mask is ignored.
+func listenUnix(path string, mask uint64) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,363 @@
+package sshost
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_parseForwarding(t *testing.T) {
+	tests := []struct {
+		spec         string
+		gatewayPorts bool
+		want         Forwarding
+		wantErr      bool
+	}{
+		{"8080 example.com:80", false, Forwarding{ForwardAddr{"tcp", "localhost:8080"}, ForwardAddr{"tcp", "example.com:80"}}, false},
+		{"8080 example.com:80", true, Forwarding{ForwardAddr{"tcp", ":8080"}, ForwardAddr{"tcp", "example.com:80"}}, false},
+		{":8080 example.com/80", false, Forwarding{ForwardAddr{"tcp", ":8080"}, ForwardAddr{"tcp", "example.com:80"}}, false},
+		{"*:8080 example.com:80", false, Forwarding{ForwardAddr{"tcp", ":8080"}, ForwardAddr{"tcp", "example.com:80"}}, false},
+		{"127.0.0.1:8080 [::1]:80", false, Forwarding{ForwardAddr{"tcp", "127.0.0.1:8080"}, ForwardAddr{"tcp", "[::1]:80"}}, false},
+		{"[::1]/8080 [::1]/80", false, Forwarding{ForwardAddr{"tcp", "[::1]:8080"}, ForwardAddr{"tcp", "[::1]:80"}}, false},
+		{"/tmp/local.sock /run/remote.sock", false, Forwarding{ForwardAddr{"unix", "/tmp/local.sock"}, ForwardAddr{"unix", "/run/remote.sock"}}, false},
+		{"~/local.sock example.com:80", false, Forwarding{ForwardAddr{"unix", "~/local.sock"}, ForwardAddr{"tcp", "example.com:80"}}, false},
+
+		{"8080", false, Forwarding{}, true},
+		{"8080 80", false, Forwarding{}, true},
+		{"8080 :80", false, Forwarding{}, true},
+		{"8080 example.com:http", false, Forwarding{}, true},
+		{"99999 example.com:80", false, Forwarding{}, true},
+		{"[::1 example.com:80", false, Forwarding{}, true},
+		{"8080 example.com:80 extra", false, Forwarding{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseForwarding(tt.spec, tt.gatewayPorts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseForwarding() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseForwarding() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRemoteForwarding(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Forwarding
+		wantErr bool
+	}{
+		{"8080 localhost:80", Forwarding{ForwardAddr{"tcp", "localhost:8080"}, ForwardAddr{"tcp", "localhost:80"}}, false},
+		{"8080", Forwarding{Listen: ForwardAddr{"tcp", "localhost:8080"}}, false},
+		{"*:1080", Forwarding{Listen: ForwardAddr{"tcp", ":1080"}}, false},
+		{"example.com:http", Forwarding{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRemoteForwarding(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRemoteForwarding() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseRemoteForwarding() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr && got.Dynamic() != (tt.want.Connect == ForwardAddr{}) {
+				t.Errorf("Forwarding.Dynamic() = %v", got.Dynamic())
+			}
+		})
+	}
+}
+
+func Test_parseDynamicForwarding(t *testing.T) {
+	tests := []struct {
+		spec         string
+		gatewayPorts bool
+		want         Forwarding
+		wantErr      bool
+	}{
+		{"1080", false, Forwarding{Listen: ForwardAddr{"tcp", "localhost:1080"}}, false},
+		{"1080", true, Forwarding{Listen: ForwardAddr{"tcp", ":1080"}}, false},
+		{" 127.0.0.1:1080 ", false, Forwarding{Listen: ForwardAddr{"tcp", "127.0.0.1:1080"}}, false},
+		{"/tmp/socks.sock", false, Forwarding{}, true},
+		{"socks", false, Forwarding{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseDynamicForwarding(tt.spec, tt.gatewayPorts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseDynamicForwarding() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseDynamicForwarding() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newLoopbackClient returns a client connected to an in-process server.
+// The server only accepts "direct-tcpip" channels, and connects them to the requested address.
+func newLoopbackClient(t *testing.T) *ssh.Client {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "direct-tcpip" {
+				newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+				continue
+			}
+
+			var payload struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+
+			remote, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.FormatUint(uint64(payload.Port), 10)))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				remote.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				defer channel.Close()
+				defer remote.Close()
+
+				go io.Copy(remote, channel)
+				io.Copy(channel, remote)
+			}()
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "user",
+		HostKeyCallback: ssh.FixedHostKey(signer.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// startEchoServer starts a tcp server on localhost that echoes everything it receives
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// checkEcho writes a message to addr and checks that it is echoed back
+func checkEcho(t *testing.T, addr ForwardAddr) {
+	t.Helper()
+
+	conn, err := net.Dial(addr.Network, addr.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	message := "hello world"
+	if _, err := io.WriteString(conn, message); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != message {
+		t.Errorf("received %q, want %q", got, message)
+	}
+}
+
+func TestProfile_LocalForward(t *testing.T) {
+	client := newLoopbackClient(t)
+	connect := ForwardAddr{Network: "tcp", Address: startEchoServer(t)}
+
+	t.Run("tcp", func(t *testing.T) {
+		profile := newTestProfile(t, "")
+
+		fwd, err := profile.LocalForward(context.Background(), client, ForwardAddr{Network: "tcp", Address: "127.0.0.1:0"}, connect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fwd.Close()
+
+		addr := fwd.(*forwarder).Addr()
+		checkEcho(t, ForwardAddr{Network: addr.Network(), Address: addr.String()})
+	})
+
+	t.Run("unix", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("socket permissions are not supported on windows")
+		}
+
+		profile := newTestProfile(t, "StreamLocalBindMask 0117\n")
+		listen := ForwardAddr{Network: "unix", Address: filepath.Join(t.TempDir(), "forward.sock")}
+
+		fwd, err := profile.LocalForward(context.Background(), client, listen, connect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fwd.Close()
+
+		info, err := os.Stat(listen.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := info.Mode().Perm(), os.FileMode(0660); got != want {
+			t.Errorf("socket has mode %v, want %v", got, want)
+		}
+
+		// the temporary directory the socket was created in is gone
+		entries, err := os.ReadDir(filepath.Dir(listen.Address))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("socket directory has %d entries, want 1", len(entries))
+		}
+
+		checkEcho(t, listen)
+
+		fwd.Close()
+		if _, err := os.Stat(listen.Address); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("socket still exists after close: %v", err)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		profile := newTestProfile(t, "")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		fwd, err := profile.LocalForward(ctx, client, ForwardAddr{Network: "tcp", Address: "127.0.0.1:0"}, connect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := fwd.(*forwarder).Addr()
+
+		cancel()
+		<-fwd.(*forwarder).done
+
+		if conn, err := net.Dial(addr.Network(), addr.String()); err == nil {
+			conn.Close()
+			t.Error("forwarder still accepts connections after ctx was cancelled")
+		}
+	})
+}
+
+func TestProfile_startForwarders(t *testing.T) {
+	client := newLoopbackClient(t)
+
+	// occupy a port, so that forwarding from it fails
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	config := "LocalForward " + busy.Addr().String() + " 127.0.0.1:22\n"
+
+	t.Run("warn", func(t *testing.T) {
+		profile := newTestProfile(t, config)
+
+		var warnings []error
+		profile.env.Warn = func(err error) { warnings = append(warnings, err) }
+
+		stack := closer.NewStack()
+		defer stack.Close()
+
+		if err := profile.startForwarders(client, stack); err != nil {
+			t.Fatalf("startForwarders() returned error %v", err)
+		}
+		if len(warnings) != 1 {
+			t.Fatalf("got %d warnings, want 1", len(warnings))
+		}
+		var failed ErrForwardFailed
+		if !errors.As(warnings[0], &failed) {
+			t.Errorf("warning %v is not an ErrForwardFailed", warnings[0])
+		}
+	})
+
+	t.Run("exit", func(t *testing.T) {
+		profile := newTestProfile(t, config+"ExitOnForwardFailure yes\n")
+
+		stack := closer.NewStack()
+		defer stack.Close()
+
+		var failed ErrForwardFailed
+		if err := profile.startForwarders(client, stack); !errors.As(err, &failed) {
+			t.Errorf("startForwarders() returned error %v, want ErrForwardFailed", err)
+		}
+	})
+}
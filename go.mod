@@ -1,12 +1,12 @@
 module github.com/tkw1536/sshost
 
-go 1.18
+go 1.23.0
 
 require (
 	github.com/kevinburke/ssh_config v1.1.0
 	github.com/tkw1536/stringreader v0.2.0
-	golang.org/x/crypto v0.17.0
-	golang.org/x/term v0.15.0
+	golang.org/x/crypto v0.39.0
+	golang.org/x/term v0.32.0
 )
 
-require golang.org/x/sys v0.15.0 // indirect
+require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,245 @@
+package sshost
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+	"github.com/tkw1536/sshost/internal/pkg/krl"
+	"github.com/tkw1536/sshost/internal/pkg/pattern"
+	"github.com/tkw1536/sshost/internal/pkg/slices"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var revokedHostKeysFlags = expand.Flags{
+	Environment: true,
+	Tilde:       true,
+	Tokens:      "%CdhijkLlnpru",
+}
+
+// RevokedHostKeys returns the expanded RevokedHostKeys setting of this profile.
+// When no file is configured, returns the empty string.
+func (profile *Profile) RevokedHostKeys() (string, error) {
+	file := profile.config.RevokedHostKeys
+	if file == "" || file == "none" {
+		return "", nil
+	}
+
+	ex := profile.expander()
+	return ex.Expand(file, revokedHostKeysFlags)
+}
+
+// hostAuthority is a @cert-authority entry of a known_hosts file
+type hostAuthority struct {
+	patterns []string
+	key      []byte // marshaled key
+}
+
+// hostMarkers holds the @cert-authority and @revoked entries of known_hosts files,
+// and the keys revoked by the RevokedHostKeys file.
+type hostMarkers struct {
+	authorities []hostAuthority
+	revoked     map[string]struct{} // marshaled keys
+	krl         *krl.KRL            // may be nil
+}
+
+// readHostMarkers reads the @cert-authority and @revoked entries of the given known_hosts files, and the revokedHostKeys file.
+// When revokedHostKeys is empty, it is not read.
+func readHostMarkers(files []string, revokedHostKeys string) (*hostMarkers, error) {
+	markers := &hostMarkers{revoked: make(map[string]struct{})}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		markers.parse(data)
+	}
+
+	if revokedHostKeys != "" {
+		data, err := os.ReadFile(revokedHostKeys)
+		if err != nil {
+			return nil, err
+		}
+		markers.krl, err = krl.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return markers, nil
+}
+
+// parse adds the markers from the known_hosts data.
+// Invalid lines are skipped.
+func (markers *hostMarkers) parse(data []byte) {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+		if err != nil {
+			continue
+		}
+
+		switch marker {
+		case "cert-authority":
+			markers.authorities = append(markers.authorities, hostAuthority{patterns: hosts, key: key.Marshal()})
+		case "revoked":
+			markers.revoked[string(key.Marshal())] = struct{}{}
+		}
+	}
+}
+
+// isRevoked checks if key is revoked.
+// For certificates, also checks the certified key and the key of the CA.
+func (markers *hostMarkers) isRevoked(key ssh.PublicKey) bool {
+	if markers.krl != nil && markers.krl.IsRevoked(key) {
+		return true
+	}
+
+	keys := []ssh.PublicKey{key}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		keys = append(keys, cert.Key, cert.SignatureKey)
+	}
+	for _, key := range keys {
+		if _, ok := markers.revoked[string(key.Marshal())]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isAuthority checks if key is a certificate authority for address
+func (markers *hostMarkers) isAuthority(key ssh.PublicKey, address string) bool {
+	blob := key.Marshal()
+	for _, authority := range markers.authorities {
+		if bytes.Equal(authority.key, blob) && matchKnownHost(authority.patterns, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKnownHost checks if the host patterns of a known_hosts entry match address.
+func matchKnownHost(patterns []string, address string) bool {
+	host := knownhosts.Normalize(address)
+
+	plain := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "|1|") {
+			if matchHashedHost(p, host) {
+				return true
+			}
+			continue
+		}
+		plain = append(plain, p)
+	}
+	return pattern.MatchAny(plain, host, true)
+}
+
+// matchHashedHost checks if a hashed known_hosts entry matches host.
+// entry is of the form "|1|salt|hash".
+func matchHashedHost(entry string, host string) bool {
+	parts := strings.Split(entry, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// hostCertCallback wraps callback to verify host certificates, and to reject revoked keys.
+//
+// Certificates are verified using the @cert-authority entries of the known_hosts files, taking into account CASignatureAlgorithms.
+// When the certificate is not signed by a @cert-authority that applies to the host, the certified key is passed to callback instead.
+// Keys revoked by a @revoked entry or the RevokedHostKeys file result in an error of type ErrHostKeyRevoked.
+func (profile *Profile) hostCertCallback(callback ssh.HostKeyCallback, markers *hostMarkers) ssh.HostKeyCallback {
+	algorithms := profile.config.CASignatureAlgorithms
+	if algorithms == nil {
+		algorithms = defaultCASigAlgos
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: markers.isAuthority,
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if markers.isRevoked(key) {
+			return ErrHostKeyRevoked{
+				Hostname:    hostname,
+				Fingerprint: ssh.FingerprintSHA256(key),
+			}
+		}
+
+		cert, ok := key.(*ssh.Certificate)
+		if !ok {
+			return callback(hostname, remote, key)
+		}
+		if !markers.isAuthority(cert.SignatureKey, hostname) {
+			return callback(hostname, remote, cert.Key)
+		}
+
+		if cert.Signature == nil || !slices.Contains(algorithms, cert.Signature.Format) {
+			format := ""
+			if cert.Signature != nil {
+				format = cert.Signature.Format
+			}
+			return ErrHostCertificate{Hostname: hostname, Err: ErrCASignatureAlgorithm{Algorithm: format}}
+		}
+		if err := checker.CheckHostKey(hostname, remote, cert); err != nil {
+			return ErrHostCertificate{Hostname: hostname, Err: err}
+		}
+		return nil
+	}
+}
+
+// ErrHostKeyRevoked is returned when the key of a remote host has been revoked
+type ErrHostKeyRevoked struct {
+	Hostname    string
+	Fingerprint string
+}
+
+func (err ErrHostKeyRevoked) Error() string {
+	return fmt.Sprintf("host key %s for %q has been revoked", err.Fingerprint, err.Hostname)
+}
+
+// ErrHostCertificate is returned when the certificate of a remote host is not valid
+type ErrHostCertificate struct {
+	Hostname string
+	Err      error
+}
+
+func (err ErrHostCertificate) Error() string {
+	return fmt.Sprintf("invalid host certificate for %q: %s", err.Hostname, err.Err)
+}
+
+func (err ErrHostCertificate) Unwrap() error {
+	return err.Err
+}
+
+// ErrCASignatureAlgorithm is returned when a certificate was signed using an algorithm not allowed by CASignatureAlgorithms
+type ErrCASignatureAlgorithm struct {
+	Algorithm string
+}
+
+func (err ErrCASignatureAlgorithm) Error() string {
+	return fmt.Sprintf("certificate signature algorithm %q is not allowed", err.Algorithm)
+}
@@ -0,0 +1,85 @@
+package sshost
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSigner generates a new ed25519 signer
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestProfile_hostCertCallback(t *testing.T) {
+	hostKey := newTestSigner(t)
+	trusted := newTestSigner(t)
+	other := newTestSigner(t)
+
+	markers := &hostMarkers{revoked: make(map[string]struct{})}
+	markers.parse([]byte("@cert-authority example.com " + string(ssh.MarshalAuthorizedKey(trusted.PublicKey()))))
+
+	newCert := func(ca ssh.Signer) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             hostKey.PublicKey(),
+			CertType:        ssh.HostCert,
+			ValidPrincipals: []string{"example.com"},
+			ValidBefore:     ssh.CertTimeInfinity,
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	errUnknown := errors.New("unknown host key")
+
+	tests := []struct {
+		name      string
+		key       ssh.PublicKey
+		known     ssh.PublicKey // key accepted by the plain known_hosts callback
+		wantPlain bool          // the plain callback is used
+		wantErr   error
+	}{
+		{"plain key", hostKey.PublicKey(), hostKey.PublicKey(), true, nil},
+		{"trusted authority", newCert(trusted), nil, false, nil},
+		{"other authority with known key", newCert(other), hostKey.PublicKey(), true, nil},
+		{"other authority with unknown key", newCert(other), nil, true, errUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var plain bool
+			callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				plain = true
+				if tt.known != nil && bytes.Equal(key.Marshal(), tt.known.Marshal()) {
+					return nil
+				}
+				return errUnknown
+			}
+
+			profile := &Profile{}
+			err := profile.hostCertCallback(callback, markers)("example.com:22", nil, tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("hostCertCallback() error = %v, want %v", err, tt.wantErr)
+			}
+			if plain != tt.wantPlain {
+				t.Errorf("hostCertCallback() used plain callback = %v, want %v", plain, tt.wantPlain)
+			}
+		})
+	}
+}
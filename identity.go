@@ -1,23 +1,45 @@
 package sshost
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/tkw1536/sshost/internal/pkg/expand"
 )
 
-func (profile Profile) expander() expand.Expander {
+func (profile *Profile) expander() expand.Expander {
 	return expand.Expander{
-		Getenv: profile.env.getenv,
+		Getenv:  profile.env.getenv,
+		Context: profile.tokens(),
+	}
+}
+
+// tokens returns the values of '%' tokens for this profile
+func (profile *Profile) tokens() expand.TokenContext {
+	local := profile.env.Local
+	return expand.TokenContext{
+		LocalHome:     local.Home,
+		RemoteHost:    profile.config.Hostname,
+		LocalUID:      local.UID,
+		ProxyJump:     strings.Join(profile.config.ProxyJump, ","),
+		HostKeyAlias:  profile.config.OriginalHost,
+		LocalHostname: local.Hostname,
+		OriginalHost:  profile.config.OriginalHost,
+		RemotePort:    strconv.FormatUint(uint64(profile.config.Port), 10),
+		RemoteUser:    profile.config.Username,
+		Tunnel:        "NONE",
+		LocalUser:     local.Username,
 	}
 }
 
 var identityFileFlags = expand.Flags{
 	Environment: true,
 	Tilde:       true,
-	Tokens:      "%CdhikLlnpru",
+	Tokens:      "%CdhijkLlnpru",
 }
 
 // IdentityFile returns the IdentityFile being used by this profile
-func (profile Profile) IdentityFile() []string {
+func (profile *Profile) IdentityFile() []string {
 	result := make([]string, 0, len(profile.config.IdentityFile))
 	ex := profile.expander()
 	for _, id := range profile.config.IdentityFile {
@@ -33,11 +55,11 @@ func (profile Profile) IdentityFile() []string {
 var identityAgentFlags = expand.Flags{
 	Environment: true,
 	Tilde:       true,
-	Tokens:      "%CdhikLlnpru",
+	Tokens:      "%CdhijkLlnpru",
 }
 
 // IdentityAgent returns the identity agent to connect to
-func (profile Profile) IdentityAgent() string {
+func (profile *Profile) IdentityAgent() string {
 	agent := profile.config.IdentityAgent
 
 	if agent == "none" || agent == "" {
@@ -0,0 +1,49 @@
+package sshost
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfile_expandTokens(t *testing.T) {
+	profile := &Profile{
+		env: &Environment{
+			Local: Local{Home: "/home/user"},
+			Variables: func(name string) string {
+				if name == "HOME" {
+					return "/home/user"
+				}
+				return ""
+			},
+		},
+		config: Config{
+			Hostname:        "example.com",
+			Port:            22,
+			Username:        "user",
+			ProxyJump:       []string{"jump1", "jump2"},
+			IdentityFile:    []string{"~/.ssh/%j/id", "%d/.ssh/%h"},
+			CertificateFile: []string{"~/.ssh/%j-cert.pub"},
+			ControlPath:     "~/.ssh/cm-%j",
+			IdentityAgent:   "~/.ssh/agent-%j",
+			UserKnownHostsFile: []string{
+				"~/.ssh/known_hosts_%j",
+			},
+		},
+	}
+
+	if got, want := profile.IdentityFile(), []string{"/home/user/.ssh/jump1,jump2/id", "/home/user/.ssh/example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IdentityFile() = %v, want %v", got, want)
+	}
+	if got, want := profile.CertificateFile(), []string{"/home/user/.ssh/jump1,jump2-cert.pub"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CertificateFile() = %v, want %v", got, want)
+	}
+	if got, want := profile.IdentityAgent(), "/home/user/.ssh/agent-jump1,jump2"; got != want {
+		t.Errorf("IdentityAgent() = %q, want %q", got, want)
+	}
+	if got, err := profile.ControlPath(); err != nil || got != "/home/user/.ssh/cm-jump1,jump2" {
+		t.Errorf("ControlPath() = %q, %v, want %q", got, err, "/home/user/.ssh/cm-jump1,jump2")
+	}
+	if got, err := profile.UserKnownHostsFile(); err != nil || !reflect.DeepEqual(got, []string{"/home/user/.ssh/known_hosts_jump1,jump2"}) {
+		t.Errorf("UserKnownHostsFile() = %v, %v, want %v", got, err, []string{"/home/user/.ssh/known_hosts_jump1,jump2"})
+	}
+}
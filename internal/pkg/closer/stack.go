@@ -22,9 +22,18 @@ func (stack *Stack) Push(closers ...Closer) {
 	stack.closers = append(stack.closers, closers...)
 }
 
-// PushStack is like Push, except that it takes a Stack as argument
+// PushStack is like Push, except that it takes a Stack as argument.
+// When other is nil, does nothing.
 func (stack *Stack) PushStack(other *Stack) {
-	stack.Push(stack.closers...)
+	if other == nil {
+		return
+	}
+
+	other.m.RLock()
+	closers := append([]Closer(nil), other.closers...)
+	other.m.RUnlock()
+
+	stack.Push(closers...)
 }
 
 // Reset resets this stack to an empty state.
@@ -21,3 +21,18 @@ func ExampleStack() {
 	// second closer
 	// error: "first closer errored"
 }
+
+func ExampleStack_PushStack() {
+	inner := closer.NewStack()
+	inner.Push(closer.NewCloser(func() error { fmt.Println("inner closer"); return nil }))
+
+	stack := closer.NewStack()
+	stack.Push(closer.NewCloser(func() error { fmt.Println("outer closer"); return nil }))
+	stack.PushStack(inner)
+	stack.PushStack(nil)
+
+	stack.Close()
+	// Output:
+	// inner closer
+	// outer closer
+}
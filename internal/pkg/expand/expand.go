@@ -11,6 +11,9 @@ import (
 // This struct holds context required for expansion.
 type Expander struct {
 	Getenv func(string) string
+
+	// Context holds the values of '%' tokens
+	Context TokenContext
 }
 
 // Flags determines which expands an Expander should perform.
@@ -38,3 +38,31 @@ func ExampleExpander_Expand() {
 	// ${HOME}/%%/${SOMETHING}
 
 }
+
+func ExampleExpander_Expand_tokens() {
+	ex := Expander{
+		Context: TokenContext{
+			LocalHome:     "/home/me",
+			RemoteHost:    "example.com",
+			LocalHostname: "laptop.local",
+			RemotePort:    "22",
+			RemoteUser:    "user",
+		},
+	}
+
+	expanded, err := ex.Expand("%d/.ssh/%r@%h:%p from %L", Flags{Tokens: AllTokens})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(expanded)
+
+	hash, err := ex.Expand("%C", Flags{Tokens: AllTokens})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(hash == ex.Context.Hash())
+
+	// Output:
+	// /home/me/.ssh/user@example.com:22 from laptop
+	// true
+}
@@ -1,6 +1,8 @@
 package expand
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"strings"
 )
@@ -18,13 +20,69 @@ func (t TokenList) Allowed(r rune) bool {
 }
 
 // AllTokens is a list of all supported tokens
-const AllTokens TokenList = "%"
+const AllTokens TokenList = "%CdhijkLlnprTu"
+
+// TokenContext holds the values '%' tokens expand to.
+// See the TOKENS section of ssh_config(5) for details.
+type TokenContext struct {
+	LocalHome     string // %d
+	RemoteHost    string // %h
+	LocalUID      string // %i
+	ProxyJump     string // %j
+	HostKeyAlias  string // %k
+	LocalHostname string // %l, %L is derived from this
+	OriginalHost  string // %n
+	RemotePort    string // %p
+	RemoteUser    string // %r
+	Tunnel        string // %T
+	LocalUser     string // %u
+}
+
+// Hash returns the hash used for the %C token.
+// It is the hex-encoded sha1 hash of %l%h%p%r%j.
+func (ctx TokenContext) Hash() string {
+	hash := sha1.Sum([]byte(ctx.LocalHostname + ctx.RemoteHost + ctx.RemotePort + ctx.RemoteUser + ctx.ProxyJump))
+	return hex.EncodeToString(hash[:])
+}
+
+// ShortLocalHostname returns the local hostname without any domain name, used for the %L token.
+func (ctx TokenContext) ShortLocalHostname() string {
+	if index := strings.IndexRune(ctx.LocalHostname, '.'); index >= 0 {
+		return ctx.LocalHostname[:index]
+	}
+	return ctx.LocalHostname
+}
 
 func (ex Expander) ExpandToken(r rune) (string, error) {
 	switch r {
 	case '%':
 		return "%", nil
-	// TODO: Support more tokens here!
+	case 'C':
+		return ex.Context.Hash(), nil
+	case 'd':
+		return ex.Context.LocalHome, nil
+	case 'h':
+		return ex.Context.RemoteHost, nil
+	case 'i':
+		return ex.Context.LocalUID, nil
+	case 'j':
+		return ex.Context.ProxyJump, nil
+	case 'k':
+		return ex.Context.HostKeyAlias, nil
+	case 'L':
+		return ex.Context.ShortLocalHostname(), nil
+	case 'l':
+		return ex.Context.LocalHostname, nil
+	case 'n':
+		return ex.Context.OriginalHost, nil
+	case 'p':
+		return ex.Context.RemotePort, nil
+	case 'r':
+		return ex.Context.RemoteUser, nil
+	case 'T':
+		return ex.Context.Tunnel, nil
+	case 'u':
+		return ex.Context.LocalUser, nil
 	default:
 		return "", fmt.Errorf("encounted unknown/unimplemented '%%' token: %q", r)
 	}
@@ -2,6 +2,7 @@ package host
 
 import (
 	"errors"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -13,38 +14,172 @@ type Host struct {
 	Host string
 	Port uint16
 
-	// TODO: Additional configuration settings!
+	// Fingerprint is the expected fingerprint of the host key.
+	// It is only set by ssh uris, see ParseHost.
+	Fingerprint string
 }
 
 var ErrHostUnsupported = errors.New("unsupported hostname")
 
-// ParseHost parses a host from a string
+// ParseHost parses a host from a string.
+//
+// host may either be of the form [user@]host[:port] or an ssh uri.
+// IPv6 literals may be enclosed in brackets, and must be when a port is given.
+// IPv6 literals may contain a zone, as in "fe80::1%eth0".
+//
+// ssh uris are of the form ssh://[user[;fingerprint=value]@]host[:port] and are described in draft-ietf-secsh-scp-sftp-ssh-uri.
+// Components of uris are percent-decoded, IPv6 literals must be enclosed in brackets.
+// Unknown connection parameters are ignored.
 func ParseHost(host string) (h Host, err error) {
 	if strings.Contains(host, "://") {
-		return h, ErrHostUnsupported
+		return parseURI(host)
 	}
-	h.Host = host
 
 	// trim off the '@' sign
-	index := strings.IndexRune(h.Host, '@')
+	index := strings.LastIndex(host, "@")
 	if index >= 0 {
-		h.User = h.Host[:index]
-		h.Host = h.Host[index+1:]
+		h.User = host[:index]
+		host = host[index+1:]
 	}
 
-	index = strings.IndexRune(h.Host, ':')
-	if index >= 0 {
-		port := h.Host[index+1:]
-		lport, err := strconv.ParseUint(port, 10, 16)
-		if err != nil {
+	var port string
+	h.Host, port, err = splitHostPort(host, true)
+	if err != nil {
+		return Host{}, err
+	}
+	if h.Port, err = parsePort(port); err != nil {
+		return Host{}, err
+	}
+	return
+}
+
+// splitHostPort splits hostport into a host and an optional port.
+//
+// Hosts enclosed in brackets are unbracketed.
+// When bare is true, hosts containing more than one ':' are treated as IPv6 literals without a port.
+func splitHostPort(hostport string, bare bool) (host, port string, err error) {
+	switch {
+	case strings.HasPrefix(hostport, "["):
+		index := strings.IndexRune(hostport, ']')
+		if index < 0 {
+			return "", "", ErrHostUnsupported
+		}
+		host, rest := hostport[1:index], hostport[index+1:]
+		if rest == "" {
+			return host, "", nil
+		}
+		if rest[0] != ':' {
+			return "", "", ErrHostUnsupported
+		}
+		return host, rest[1:], nil
+	case bare && strings.Count(hostport, ":") > 1:
+		return hostport, "", nil
+	}
+
+	index := strings.IndexRune(hostport, ':')
+	if index < 0 {
+		return hostport, "", nil
+	}
+	return hostport[:index], hostport[index+1:], nil
+}
+
+// parsePort parses an optional port
+func parsePort(port string) (uint16, error) {
+	if port == "" {
+		return 0, nil
+	}
+	lport, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(lport), nil
+}
+
+const uriScheme = "ssh://"
+
+// parseURI parses an ssh uri
+func parseURI(uri string) (h Host, err error) {
+	if len(uri) < len(uriScheme) || !strings.EqualFold(uri[:len(uriScheme)], uriScheme) {
+		return h, ErrHostUnsupported
+	}
+	rest := uri[len(uriScheme):]
+
+	// the path must be empty
+	if index := strings.IndexAny(rest, "/?#"); index >= 0 {
+		if rest[index:] != "/" {
+			return h, ErrHostUnsupported
+		}
+		rest = rest[:index]
+	}
+
+	// split off the user and connection parameters
+	if index := strings.LastIndex(rest, "@"); index >= 0 {
+		if err := h.parseUserInfo(rest[:index]); err != nil {
 			return Host{}, err
 		}
-		h.Port = uint16(lport)
+		rest = rest[index+1:]
+	}
 
-		h.Host = h.Host[:index]
+	// split host and port
+	host, port, err := splitHostPort(rest, false)
+	if err != nil {
+		return Host{}, err
 	}
 
-	return
+	if h.Host, err = url.PathUnescape(host); err != nil {
+		return Host{}, err
+	}
+	if h.Host == "" {
+		return Host{}, ErrHostUnsupported
+	}
+	if h.Port, err = parsePort(port); err != nil {
+		return Host{}, err
+	}
+
+	return h, nil
+}
+
+// parseUserInfo parses the user and connection parameters of an ssh uri into h.
+func (h *Host) parseUserInfo(info string) (err error) {
+	params := strings.Split(info, ";")
+
+	// passwords are not supported
+	if strings.ContainsRune(params[0], ':') {
+		return ErrHostUnsupported
+	}
+	if h.User, err = url.PathUnescape(params[0]); err != nil {
+		return err
+	}
+
+	for _, param := range params[1:] {
+		index := strings.IndexRune(param, '=')
+		if index < 0 {
+			return ErrHostUnsupported
+		}
+		key, err := url.PathUnescape(param[:index])
+		if err != nil {
+			return err
+		}
+		value, err := url.PathUnescape(param[index+1:])
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(key) {
+		case "fingerprint":
+			h.Fingerprint = value
+		}
+	}
+	return nil
+}
+
+// Unbracket removes brackets surrounding an IPv6 literal.
+// Other hosts are returned unchanged.
+func Unbracket(host string) string {
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		return host[1 : len(host)-1]
+	}
+	return host
 }
 
 // ValidHost checks if Host is a valid host
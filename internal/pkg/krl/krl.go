@@ -0,0 +1,338 @@
+// Package krl implements revocation lists for ssh keys and certificates.
+//
+// It reads both OpenSSH Key Revocation Lists (KRLs), see PROTOCOL.krl in the OpenSSH sources,
+// and text files listing one revoked public key per line.
+// Signatures of KRLs are not verified.
+package krl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// magic is the magic number at the start of a binary KRL
+const magic = "SSHKRL\n\x00"
+
+// formatVersion is the supported version of the KRL format
+const formatVersion = 1
+
+// section types
+const (
+	sectionCertificates      = 1
+	sectionExplicitKey       = 2
+	sectionFingerprintSHA1   = 3
+	sectionSignature         = 4
+	sectionFingerprintSHA256 = 5
+)
+
+// certificate section types
+const (
+	certSectionSerialList   = 0x20
+	certSectionSerialRange  = 0x21
+	certSectionSerialBitmap = 0x22
+	certSectionKeyID        = 0x23
+)
+
+// KRL is a list of revoked keys and certificates.
+type KRL struct {
+	keys   map[string]struct{} // marshaled keys
+	sha1   map[string]struct{} // sha1 hashes of marshaled keys
+	sha256 map[string]struct{} // sha256 hashes of marshaled keys
+
+	certs []*certSection
+}
+
+// certSection holds certificates revoked for a single certificate authority
+type certSection struct {
+	ca []byte // marshaled key of the CA, empty for any CA
+
+	serials []serialRange
+	bitmaps []serialBitmap
+	keyIDs  map[string]struct{}
+}
+
+type serialRange struct {
+	min, max uint64
+}
+
+type serialBitmap struct {
+	offset uint64
+	bits   *big.Int
+}
+
+var (
+	errMalformed     = errors.New("krl: malformed revocation list")
+	errFormatVersion = errors.New("krl: unsupported format version")
+)
+
+// Parse parses a revocation list.
+// data is either a binary KRL, or a text file with one public key per line.
+// In text files, empty lines and lines starting with '#' are ignored.
+func Parse(data []byte) (*KRL, error) {
+	krl := &KRL{
+		keys:   make(map[string]struct{}),
+		sha1:   make(map[string]struct{}),
+		sha256: make(map[string]struct{}),
+	}
+
+	if bytes.HasPrefix(data, []byte(magic)) {
+		return krl, krl.parseBinary(data[len(magic):])
+	}
+	return krl, krl.parseText(data)
+}
+
+// parseText parses a text file of public keys
+func (krl *KRL) parseText(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return err
+		}
+		krl.keys[string(key.Marshal())] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// parseBinary parses a binary KRL, without the magic number
+func (krl *KRL) parseBinary(data []byte) error {
+	r := reader{data: data}
+
+	if r.uint32() != formatVersion {
+		if r.err != nil {
+			return r.err
+		}
+		return errFormatVersion
+	}
+	r.uint64() // krl version
+	r.uint64() // generated date
+	r.uint64() // flags
+	r.string() // reserved
+	r.string() // comment
+
+	for r.err == nil && !r.empty() {
+		typ := r.byte()
+		section := reader{data: r.string()}
+		if r.err != nil {
+			break
+		}
+
+		switch typ {
+		case sectionCertificates:
+			krl.parseCertificates(&section)
+		case sectionExplicitKey:
+			krl.parseHashes(&section, krl.keys)
+		case sectionFingerprintSHA1:
+			krl.parseHashes(&section, krl.sha1)
+		case sectionFingerprintSHA256:
+			krl.parseHashes(&section, krl.sha256)
+		case sectionSignature:
+			// signatures are not verified, and must be the last section
+			return nil
+		default:
+			return errMalformed
+		}
+
+		if section.err != nil {
+			return section.err
+		}
+	}
+	return r.err
+}
+
+// parseHashes adds all strings in r to set
+func (krl *KRL) parseHashes(r *reader, set map[string]struct{}) {
+	for r.err == nil && !r.empty() {
+		set[string(r.string())] = struct{}{}
+	}
+}
+
+// parseCertificates parses a certificates section
+func (krl *KRL) parseCertificates(r *reader) {
+	section := &certSection{
+		ca:     r.string(),
+		keyIDs: make(map[string]struct{}),
+	}
+	r.string() // reserved
+
+	for r.err == nil && !r.empty() {
+		typ := r.byte()
+		sub := reader{data: r.string()}
+		if r.err != nil {
+			return
+		}
+
+		switch typ {
+		case certSectionSerialList:
+			for sub.err == nil && !sub.empty() {
+				serial := sub.uint64()
+				section.serials = append(section.serials, serialRange{min: serial, max: serial})
+			}
+		case certSectionSerialRange:
+			min, max := sub.uint64(), sub.uint64()
+			section.serials = append(section.serials, serialRange{min: min, max: max})
+		case certSectionSerialBitmap:
+			offset := sub.uint64()
+			bits := new(big.Int).SetBytes(sub.string())
+			section.bitmaps = append(section.bitmaps, serialBitmap{offset: offset, bits: bits})
+		case certSectionKeyID:
+			for sub.err == nil && !sub.empty() {
+				section.keyIDs[string(sub.string())] = struct{}{}
+			}
+		default:
+			r.err = errMalformed
+			return
+		}
+
+		if sub.err != nil {
+			r.err = sub.err
+			return
+		}
+	}
+
+	krl.certs = append(krl.certs, section)
+}
+
+// IsRevoked checks if key is revoked.
+//
+// A certificate is revoked when it is revoked explicitly, or when either its key or the key of its CA is revoked.
+func (krl *KRL) IsRevoked(key ssh.PublicKey) bool {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return krl.isCertRevoked(cert) || krl.isKeyRevoked(cert.Key) || krl.isKeyRevoked(cert.SignatureKey)
+	}
+	return krl.isKeyRevoked(key)
+}
+
+// isKeyRevoked checks if the plain key is revoked
+func (krl *KRL) isKeyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	if _, ok := krl.keys[string(blob)]; ok {
+		return true
+	}
+
+	hash1 := sha1.Sum(blob)
+	if _, ok := krl.sha1[string(hash1[:])]; ok {
+		return true
+	}
+
+	hash256 := sha256.Sum256(blob)
+	if _, ok := krl.sha256[string(hash256[:])]; ok {
+		return true
+	}
+
+	return false
+}
+
+// isCertRevoked checks if cert is revoked by a certificates section
+func (krl *KRL) isCertRevoked(cert *ssh.Certificate) bool {
+	ca := cert.SignatureKey.Marshal()
+	for _, section := range krl.certs {
+		if len(section.ca) != 0 && !bytes.Equal(section.ca, ca) {
+			continue
+		}
+		if section.revokes(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// revokes checks if this section revokes cert
+func (section *certSection) revokes(cert *ssh.Certificate) bool {
+	if _, ok := section.keyIDs[cert.KeyId]; ok {
+		return true
+	}
+
+	// serials are only meaningful for a specific CA
+	if len(section.ca) == 0 {
+		return false
+	}
+
+	for _, serials := range section.serials {
+		if cert.Serial >= serials.min && cert.Serial <= serials.max {
+			return true
+		}
+	}
+	for _, bitmap := range section.bitmaps {
+		if cert.Serial < bitmap.offset {
+			continue
+		}
+		index := cert.Serial - bitmap.offset
+		if index < uint64(bitmap.bits.BitLen()) && bitmap.bits.Bit(int(index)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// reader reads values in the ssh wire format.
+// Once an error occurs, all further reads return zero values.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data) < n {
+		r.err = errMalformed
+		return nil
+	}
+	value := r.data[:n]
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *reader) byte() byte {
+	value := r.next(1)
+	if value == nil {
+		return 0
+	}
+	return value[0]
+}
+
+func (r *reader) uint32() uint32 {
+	value := r.next(4)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(value)
+}
+
+func (r *reader) uint64() uint64 {
+	value := r.next(8)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+func (r *reader) string() []byte {
+	length := r.uint32()
+	if r.err != nil {
+		return nil
+	}
+	if uint64(length) > uint64(len(r.data)) {
+		r.err = errMalformed
+		return nil
+	}
+	return r.next(int(length))
+}
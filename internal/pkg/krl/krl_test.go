@@ -0,0 +1,93 @@
+package krl_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tkw1536/sshost/internal/pkg/krl"
+	"golang.org/x/crypto/ssh"
+)
+
+// the files in testdata were generated using ssh-keygen.
+// krl revokes serial 5, serials 10-20 and key id "cert-revoked-id" of certificates signed by ca,
+// the key host2 explicitly, and the key host4 by its sha256 hash.
+// revoked.txt lists the key host2.
+
+func readKey(t *testing.T, name string) ssh.PublicKey {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func readKRL(t *testing.T, name string) *krl.KRL {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := krl.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestKRL_IsRevoked(t *testing.T) {
+	tests := []struct {
+		key    string
+		binary bool
+		text   bool
+	}{
+		{"ca.pub", false, false},
+		{"host1.pub", false, false},
+		{"host1-cert.pub", true, false}, // serial 5
+		{"host2.pub", true, true},
+		{"host2-cert.pub", true, true}, // key revoked
+		{"host3.pub", false, false},
+		{"host3-cert.pub", true, false}, // key id
+		{"host4.pub", true, false},
+		{"host4-cert.pub", true, false}, // serial range
+		{"valid-cert.pub", false, false},
+	}
+
+	binary := readKRL(t, "krl")
+	text := readKRL(t, "revoked.txt")
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			key := readKey(t, tt.key)
+			if got := binary.IsRevoked(key); got != tt.binary {
+				t.Errorf("binary KRL: IsRevoked() = %v, want %v", got, tt.binary)
+			}
+			if got := text.IsRevoked(key); got != tt.text {
+				t.Errorf("text KRL: IsRevoked() = %v, want %v", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestParse_malformed(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "krl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{12, 50, len(data) - 3} {
+		if _, err := krl.Parse(data[:n]); err == nil {
+			t.Errorf("Parse() of KRL truncated to %d bytes did not fail", n)
+		}
+	}
+	if _, err := krl.Parse([]byte("not a key\n")); err == nil {
+		t.Error("Parse() of invalid text did not fail")
+	}
+}
@@ -0,0 +1,91 @@
+package mux
+
+import (
+	"fmt"
+	"net"
+)
+
+// Dial connects to the master listening at path, and requests proxy mode.
+// The returned Conn uses the connection of the master.
+//
+// user is reported by the User method of the returned connection.
+func Dial(path string, user string) (*Conn, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := clientHello(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := requestProxy(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return NewConn(conn, Metadata{
+		User:       user,
+		LocalAddr:  conn.LocalAddr(),
+		RemoteAddr: conn.RemoteAddr(),
+	}), nil
+}
+
+// clientHello exchanges hello messages with the master.
+func clientHello(conn net.Conn) error {
+	packet, err := readPacket(conn)
+	if err != nil {
+		return err
+	}
+
+	r := reader{data: packet}
+	if typ := r.uint32(); typ != msgHello {
+		return fmt.Errorf("mux: expected hello message, got %#x", typ)
+	}
+	if version := r.uint32(); r.err == nil && version != Version {
+		return fmt.Errorf("mux: unsupported protocol version %d", version)
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	hello := newMessage(msgHello)
+	hello.putUint32(Version)
+	return writePacket(conn, hello.data)
+}
+
+// requestProxy requests proxy mode from the master
+func requestProxy(conn net.Conn) error {
+	const id = 0
+
+	request := newMessage(msgProxy)
+	request.putUint32(id)
+	if err := writePacket(conn, request.data); err != nil {
+		return err
+	}
+
+	packet, err := readPacket(conn)
+	if err != nil {
+		return err
+	}
+
+	r := reader{data: packet}
+	typ := r.uint32()
+	if rid := r.uint32(); r.err == nil && rid != id {
+		return fmt.Errorf("mux: reply for unexpected request %d", rid)
+	}
+
+	switch typ {
+	case msgProxyOK:
+		return r.err
+	case msgPermissionDenied, msgFailure:
+		reason := r.string()
+		if r.err != nil {
+			return r.err
+		}
+		return ErrRefused{PermissionDenied: typ == msgPermissionDenied, Reason: reason}
+	default:
+		return fmt.Errorf("mux: unexpected reply %#x", typ)
+	}
+}
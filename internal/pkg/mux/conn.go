@@ -0,0 +1,866 @@
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// messages of the ssh connection protocol, see RFC 4254
+const (
+	msgGlobalRequest      = 80
+	msgRequestSuccess     = 81
+	msgRequestFailure     = 82
+	msgChannelOpen        = 90
+	msgChannelOpenConfirm = 91
+	msgChannelOpenFailure = 92
+	msgChannelWindow      = 93
+	msgChannelData        = 94
+	msgChannelExtended    = 95
+	msgChannelEOF         = 96
+	msgChannelClose       = 97
+	msgChannelRequest     = 98
+	msgChannelSuccess     = 99
+	msgChannelFailure     = 100
+)
+
+type globalRequestMsg struct {
+	Type      string `sshtype:"80"`
+	WantReply bool
+	Data      []byte `ssh:"rest"`
+}
+
+type globalRequestSuccessMsg struct {
+	Data []byte `ssh:"rest" sshtype:"81"`
+}
+
+type globalRequestFailureMsg struct {
+	Data []byte `ssh:"rest" sshtype:"82"`
+}
+
+type channelOpenMsg struct {
+	ChanType      string `sshtype:"90"`
+	PeersID       uint32
+	PeersWindow   uint32
+	MaxPacketSize uint32
+	Data          []byte `ssh:"rest"`
+}
+
+type channelOpenConfirmMsg struct {
+	PeersID       uint32 `sshtype:"91"`
+	MyID          uint32
+	MyWindow      uint32
+	MaxPacketSize uint32
+	Data          []byte `ssh:"rest"`
+}
+
+type channelOpenFailureMsg struct {
+	PeersID  uint32 `sshtype:"92"`
+	Reason   ssh.RejectionReason
+	Message  string
+	Language string
+}
+
+type windowAdjustMsg struct {
+	PeersID         uint32 `sshtype:"93"`
+	AdditionalBytes uint32
+}
+
+type channelDataMsg struct {
+	PeersID uint32 `sshtype:"94"`
+	Data    []byte
+}
+
+type channelExtendedDataMsg struct {
+	PeersID uint32 `sshtype:"95"`
+	Code    uint32
+	Data    []byte
+}
+
+type channelEOFMsg struct {
+	PeersID uint32 `sshtype:"96"`
+}
+
+type channelCloseMsg struct {
+	PeersID uint32 `sshtype:"97"`
+}
+
+type channelRequestMsg struct {
+	PeersID   uint32 `sshtype:"98"`
+	Request   string
+	WantReply bool
+	Data      []byte `ssh:"rest"`
+}
+
+type channelRequestSuccessMsg struct {
+	PeersID uint32 `sshtype:"99"`
+}
+
+type channelRequestFailureMsg struct {
+	PeersID uint32 `sshtype:"100"`
+}
+
+const (
+	// channelWindowSize is the initial window of channels opened or accepted by a Conn
+	channelWindowSize = 2 * 1024 * 1024
+
+	// channelMaxPacket is the maximal size of data packets accepted by a Conn
+	channelMaxPacket = 32 * 1024
+)
+
+// Metadata holds information about a Conn.
+type Metadata struct {
+	User       string
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+}
+
+// Conn speaks the ssh connection protocol over an unencrypted stream, as used by masters in proxy mode.
+//
+// Conn implements ssh.Conn, and can be used to create an ssh.Client using NewClient.
+type Conn struct {
+	rw   io.ReadWriteCloser
+	meta Metadata
+
+	writeM sync.Mutex // held while writing a packet
+
+	m        sync.Mutex // protects channels and nextID
+	channels map[uint32]*Channel
+	nextID   uint32
+
+	globalM       sync.Mutex // held while waiting for the reply to a global request
+	globalReplies chan globalReply
+
+	newChannels chan *NewChannel
+	requests    chan *Request
+
+	done chan struct{}
+	err  error // error that caused the shutdown, set before done is closed
+}
+
+type globalReply struct {
+	ok      bool
+	payload []byte
+}
+
+// NewConn creates a new Conn using rw, and starts handling incoming messages.
+// The channels returned by Incoming must be serviced, or the connection will hang.
+func NewConn(rw io.ReadWriteCloser, meta Metadata) *Conn {
+	conn := &Conn{
+		rw:   rw,
+		meta: meta,
+
+		channels: make(map[uint32]*Channel),
+
+		globalReplies: make(chan globalReply, 1),
+
+		newChannels: make(chan *NewChannel, 16),
+		requests:    make(chan *Request, 16),
+
+		done: make(chan struct{}),
+	}
+	go conn.loop()
+	return conn
+}
+
+// Incoming returns channels of incoming channel requests and global requests.
+// Unlike with ssh.Conn, incoming requests can be replied to with an arbitrary payload.
+func (conn *Conn) Incoming() (<-chan *NewChannel, <-chan *Request) {
+	return conn.newChannels, conn.requests
+}
+
+// NewClient creates a new ssh.Client using conn.
+func NewClient(conn *Conn) *ssh.Client {
+	chans := make(chan ssh.NewChannel, 16)
+	go func() {
+		defer close(chans)
+		for nc := range conn.newChannels {
+			chans <- nc
+		}
+	}()
+	return ssh.NewClient(conn, chans, sshRequests(conn.requests))
+}
+
+// sshRequests turns a channel of requests into a channel of ssh.Requests.
+// Requests wanting a reply are rejected, and delivered without WantReply.
+func sshRequests(in <-chan *Request) <-chan *ssh.Request {
+	out := make(chan *ssh.Request, 16)
+	go func() {
+		defer close(out)
+		for req := range in {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			out <- &ssh.Request{Type: req.Type, Payload: req.Payload}
+		}
+	}()
+	return out
+}
+
+func (conn *Conn) User() string          { return conn.meta.User }
+func (conn *Conn) SessionID() []byte     { return nil }
+func (conn *Conn) ClientVersion() []byte { return nil }
+func (conn *Conn) ServerVersion() []byte { return nil }
+func (conn *Conn) RemoteAddr() net.Addr  { return conn.meta.RemoteAddr }
+func (conn *Conn) LocalAddr() net.Addr   { return conn.meta.LocalAddr }
+
+// Close closes the underlying connection
+func (conn *Conn) Close() error {
+	return conn.rw.Close()
+}
+
+// Wait waits until the connection has shut down, and returns the error causing the shutdown.
+func (conn *Conn) Wait() error {
+	<-conn.done
+	return conn.err
+}
+
+var errClosed = errors.New("mux: connection closed")
+
+// SendRequest sends a global request, and returns the reply if wantReply is true
+func (conn *Conn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	if wantReply {
+		conn.globalM.Lock()
+		defer conn.globalM.Unlock()
+	}
+
+	if err := conn.write(ssh.Marshal(globalRequestMsg{Type: name, WantReply: wantReply, Data: payload})); err != nil {
+		return false, nil, err
+	}
+	if !wantReply {
+		return false, nil, nil
+	}
+
+	select {
+	case reply := <-conn.globalReplies:
+		return reply.ok, reply.payload, nil
+	case <-conn.done:
+		return false, nil, errClosed
+	}
+}
+
+// OpenChannel opens a new channel.
+// Incoming requests that want a reply are automatically rejected.
+func (conn *Conn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	ch, reqs, err := conn.OpenRaw(name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ch, sshRequests(reqs), nil
+}
+
+// OpenRaw is like OpenChannel, except that incoming requests are not replied to automatically.
+func (conn *Conn) OpenRaw(name string, data []byte) (*Channel, <-chan *Request, error) {
+	ch := conn.newChannel(name, data)
+
+	err := conn.write(ssh.Marshal(channelOpenMsg{
+		ChanType:      name,
+		PeersID:       ch.localID,
+		PeersWindow:   channelWindowSize,
+		MaxPacketSize: channelMaxPacket,
+		Data:          data,
+	}))
+	if err != nil {
+		conn.removeChannel(ch.localID)
+		return nil, nil, err
+	}
+
+	var result interface{}
+	select {
+	case result = <-ch.opened:
+	case <-conn.done:
+		return nil, nil, errClosed
+	}
+
+	switch msg := result.(type) {
+	case *channelOpenConfirmMsg:
+		return ch, ch.requests, nil
+	case *channelOpenFailureMsg:
+		conn.removeChannel(ch.localID)
+		return nil, nil, &ssh.OpenChannelError{Reason: msg.Reason, Message: msg.Message}
+	default:
+		panic("never reached")
+	}
+}
+
+// newChannel creates and registers a new channel
+func (conn *Conn) newChannel(name string, data []byte) *Channel {
+	ch := &Channel{
+		conn:  conn,
+		typ:   name,
+		extra: data,
+
+		stdout: newBuffer(),
+		stderr: newBuffer(),
+		window: newWindow(),
+
+		opened:   make(chan interface{}, 1),
+		requests: make(chan *Request, 16),
+		replies:  make(chan bool, 1),
+		closed:   make(chan struct{}),
+	}
+
+	conn.m.Lock()
+	defer conn.m.Unlock()
+
+	ch.localID = conn.nextID
+	conn.nextID++
+
+	// the connection has been shut down
+	if conn.channels == nil {
+		return ch
+	}
+	conn.channels[ch.localID] = ch
+	return ch
+}
+
+func (conn *Conn) getChannel(id uint32) *Channel {
+	conn.m.Lock()
+	defer conn.m.Unlock()
+
+	return conn.channels[id]
+}
+
+func (conn *Conn) removeChannel(id uint32) {
+	conn.m.Lock()
+	defer conn.m.Unlock()
+
+	delete(conn.channels, id)
+}
+
+// write writes a single message to the underlying connection
+func (conn *Conn) write(msg []byte) error {
+	// packets are framed like ssh packets, but without padding or mac
+	packet := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(packet, uint32(1+len(msg)))
+	copy(packet[5:], msg)
+
+	conn.writeM.Lock()
+	defer conn.writeM.Unlock()
+
+	select {
+	case <-conn.done:
+		return errClosed
+	default:
+	}
+
+	_, err := conn.rw.Write(packet)
+	return err
+}
+
+// read reads a single message from the underlying connection
+func (conn *Conn) read() ([]byte, error) {
+	packet, err := readPacket(conn.rw)
+	if err != nil {
+		return nil, err
+	}
+	// skip the padding length
+	if len(packet) < 2 {
+		return nil, errShortMessage
+	}
+	return packet[1:], nil
+}
+
+// loop handles incoming messages until an error occurs
+func (conn *Conn) loop() {
+	var err error
+	for err == nil {
+		var msg []byte
+		msg, err = conn.read()
+		if err != nil {
+			break
+		}
+		err = conn.handle(msg)
+	}
+
+	conn.rw.Close()
+
+	conn.m.Lock()
+	channels := conn.channels
+	conn.channels = nil
+	conn.m.Unlock()
+
+	for _, ch := range channels {
+		ch.handleClose()
+	}
+
+	close(conn.newChannels)
+	close(conn.requests)
+
+	conn.err = err
+	close(conn.done)
+}
+
+var errUnexpectedMessage = errors.New("mux: unexpected message")
+
+// handle handles a single incoming message
+func (conn *Conn) handle(msg []byte) error {
+	switch msg[0] {
+	case msgGlobalRequest:
+		var req globalRequestMsg
+		if err := ssh.Unmarshal(msg, &req); err != nil {
+			return err
+		}
+		conn.requests <- &Request{
+			Type:      req.Type,
+			WantReply: req.WantReply,
+			Payload:   req.Data,
+			reply:     conn.replyGlobal,
+		}
+		return nil
+	case msgRequestSuccess, msgRequestFailure:
+		reply := globalReply{ok: msg[0] == msgRequestSuccess, payload: msg[1:]}
+		select {
+		case conn.globalReplies <- reply:
+		default:
+		}
+		return nil
+	case msgChannelOpen:
+		var open channelOpenMsg
+		if err := ssh.Unmarshal(msg, &open); err != nil {
+			return err
+		}
+		conn.newChannels <- &NewChannel{conn: conn, msg: open}
+		return nil
+	}
+
+	if len(msg) < 5 {
+		return errShortMessage
+	}
+	ch := conn.getChannel(binary.BigEndian.Uint32(msg[1:]))
+	if ch == nil {
+		// channel has already been closed
+		return nil
+	}
+
+	switch msg[0] {
+	case msgChannelOpenConfirm:
+		var confirm channelOpenConfirmMsg
+		if err := ssh.Unmarshal(msg, &confirm); err != nil {
+			return err
+		}
+		return ch.handleOpened(&confirm)
+	case msgChannelOpenFailure:
+		var failure channelOpenFailureMsg
+		if err := ssh.Unmarshal(msg, &failure); err != nil {
+			return err
+		}
+		return ch.handleOpened(&failure)
+	case msgChannelWindow:
+		var adjust windowAdjustMsg
+		if err := ssh.Unmarshal(msg, &adjust); err != nil {
+			return err
+		}
+		ch.window.add(adjust.AdditionalBytes)
+	case msgChannelData:
+		var data channelDataMsg
+		if err := ssh.Unmarshal(msg, &data); err != nil {
+			return err
+		}
+		ch.stdout.write(data.Data)
+	case msgChannelExtended:
+		var data channelExtendedDataMsg
+		if err := ssh.Unmarshal(msg, &data); err != nil {
+			return err
+		}
+		if data.Code == 1 {
+			ch.stderr.write(data.Data)
+		}
+	case msgChannelEOF:
+		ch.stdout.setEOF()
+		ch.stderr.setEOF()
+	case msgChannelClose:
+		conn.removeChannel(ch.localID)
+		ch.handleClose()
+		return ch.sendClose()
+	case msgChannelRequest:
+		var req channelRequestMsg
+		if err := ssh.Unmarshal(msg, &req); err != nil {
+			return err
+		}
+		ch.requests <- &Request{
+			Type:      req.Request,
+			WantReply: req.WantReply,
+			Payload:   req.Data,
+			reply:     ch.replyRequest,
+		}
+	case msgChannelSuccess, msgChannelFailure:
+		select {
+		case ch.replies <- msg[0] == msgChannelSuccess:
+		default:
+		}
+	default:
+		return errUnexpectedMessage
+	}
+	return nil
+}
+
+// replyGlobal replies to a global request
+func (conn *Conn) replyGlobal(ok bool, payload []byte) error {
+	if ok {
+		return conn.write(ssh.Marshal(globalRequestSuccessMsg{Data: payload}))
+	}
+	return conn.write(ssh.Marshal(globalRequestFailureMsg{Data: payload}))
+}
+
+// Request is a global or channel request received from the peer
+type Request struct {
+	Type      string
+	WantReply bool
+	Payload   []byte
+
+	reply func(ok bool, payload []byte) error
+}
+
+// Reply replies to the request, if the peer wants a reply.
+// The payload is ignored for channel requests.
+func (req *Request) Reply(ok bool, payload []byte) error {
+	if !req.WantReply {
+		return nil
+	}
+	return req.reply(ok, payload)
+}
+
+// NewChannel is a request of the peer to open a channel.
+// It implements ssh.NewChannel.
+type NewChannel struct {
+	conn *Conn
+	msg  channelOpenMsg
+}
+
+func (nc *NewChannel) ChannelType() string {
+	return nc.msg.ChanType
+}
+
+func (nc *NewChannel) ExtraData() []byte {
+	return nc.msg.Data
+}
+
+// Accept accepts the channel.
+// Incoming requests that want a reply are automatically rejected.
+func (nc *NewChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	ch, reqs, err := nc.AcceptRaw()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ch, sshRequests(reqs), nil
+}
+
+// AcceptRaw is like Accept, except that incoming requests are not replied to automatically.
+func (nc *NewChannel) AcceptRaw() (*Channel, <-chan *Request, error) {
+	ch := nc.conn.newChannel(nc.msg.ChanType, nc.msg.Data)
+	ch.remoteID = nc.msg.PeersID
+	ch.maxPacket = nc.msg.MaxPacketSize
+	ch.window.add(nc.msg.PeersWindow)
+
+	err := nc.conn.write(ssh.Marshal(channelOpenConfirmMsg{
+		PeersID:       ch.remoteID,
+		MyID:          ch.localID,
+		MyWindow:      channelWindowSize,
+		MaxPacketSize: channelMaxPacket,
+	}))
+	if err != nil {
+		nc.conn.removeChannel(ch.localID)
+		return nil, nil, err
+	}
+	return ch, ch.requests, nil
+}
+
+// Reject rejects the channel
+func (nc *NewChannel) Reject(reason ssh.RejectionReason, message string) error {
+	return nc.conn.write(ssh.Marshal(channelOpenFailureMsg{
+		PeersID: nc.msg.PeersID,
+		Reason:  reason,
+		Message: message,
+	}))
+}
+
+// Channel is a channel of a Conn.
+// It implements ssh.Channel.
+type Channel struct {
+	conn  *Conn
+	typ   string
+	extra []byte
+
+	localID   uint32
+	remoteID  uint32
+	maxPacket uint32
+
+	opened chan interface{} // receives the reply to an open request
+
+	stdout *buffer
+	stderr *buffer
+	window *window
+
+	requests chan *Request
+
+	reqM    sync.Mutex // held while waiting for the reply to a request
+	replies chan bool
+
+	m         sync.Mutex
+	sentEOF   bool
+	sentClose bool
+	gotClose  bool
+
+	closed chan struct{} // closed once the peer closed the channel
+}
+
+// handleOpened handles the reply to an open request.
+// msg is either a *channelOpenConfirmMsg or a *channelOpenFailureMsg.
+func (ch *Channel) handleOpened(msg interface{}) error {
+	if confirm, ok := msg.(*channelOpenConfirmMsg); ok {
+		ch.remoteID = confirm.MyID
+		ch.maxPacket = confirm.MaxPacketSize
+		ch.window.add(confirm.MyWindow)
+	}
+
+	select {
+	case ch.opened <- msg:
+		return nil
+	default:
+		return errUnexpectedMessage
+	}
+}
+
+// handleClose handles the channel being closed by the peer or the connection shutting down
+func (ch *Channel) handleClose() {
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	if ch.gotClose {
+		return
+	}
+	ch.gotClose = true
+
+	ch.stdout.setEOF()
+	ch.stderr.setEOF()
+	ch.window.close()
+
+	close(ch.requests)
+	close(ch.closed)
+}
+
+func (ch *Channel) Read(data []byte) (int, error) {
+	n, err := ch.stdout.Read(data)
+	ch.adjustWindow(n)
+	return n, err
+}
+
+func (ch *Channel) Write(data []byte) (int, error) {
+	return ch.writeData(data, false)
+}
+
+// writeData writes data to the peer, as extended data when extended is true.
+func (ch *Channel) writeData(data []byte, extended bool) (n int, err error) {
+	for len(data) > 0 {
+		want := uint32(len(data))
+		if ch.maxPacket > 0 && want > ch.maxPacket {
+			want = ch.maxPacket
+		}
+
+		size, err := ch.window.reserve(want)
+		if err != nil {
+			return n, err
+		}
+
+		chunk := data[:size]
+		var msg []byte
+		if extended {
+			msg = ssh.Marshal(channelExtendedDataMsg{PeersID: ch.remoteID, Code: 1, Data: chunk})
+		} else {
+			msg = ssh.Marshal(channelDataMsg{PeersID: ch.remoteID, Data: chunk})
+		}
+		if err := ch.conn.write(msg); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		data = data[size:]
+	}
+	return n, nil
+}
+
+// adjustWindow tells the peer that n more bytes may be sent
+func (ch *Channel) adjustWindow(n int) {
+	if n <= 0 {
+		return
+	}
+	ch.conn.write(ssh.Marshal(windowAdjustMsg{PeersID: ch.remoteID, AdditionalBytes: uint32(n)}))
+}
+
+// Close closes the channel
+func (ch *Channel) Close() error {
+	ch.window.close()
+	return ch.sendClose()
+}
+
+func (ch *Channel) sendClose() error {
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	if ch.sentClose {
+		return nil
+	}
+	ch.sentClose = true
+	return ch.conn.write(ssh.Marshal(channelCloseMsg{PeersID: ch.remoteID}))
+}
+
+// CloseWrite signals the end of data to the peer
+func (ch *Channel) CloseWrite() error {
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	if ch.sentEOF || ch.sentClose {
+		return nil
+	}
+	ch.sentEOF = true
+	return ch.conn.write(ssh.Marshal(channelEOFMsg{PeersID: ch.remoteID}))
+}
+
+// SendRequest sends a channel request, and waits for the reply if wantReply is true
+func (ch *Channel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	if wantReply {
+		ch.reqM.Lock()
+		defer ch.reqM.Unlock()
+	}
+
+	if err := ch.conn.write(ssh.Marshal(channelRequestMsg{PeersID: ch.remoteID, Request: name, WantReply: wantReply, Data: payload})); err != nil {
+		return false, err
+	}
+	if !wantReply {
+		return false, nil
+	}
+
+	select {
+	case ok := <-ch.replies:
+		return ok, nil
+	case <-ch.closed:
+		return false, io.EOF
+	}
+}
+
+// replyRequest replies to a channel request
+func (ch *Channel) replyRequest(ok bool, payload []byte) error {
+	if ok {
+		return ch.conn.write(ssh.Marshal(channelRequestSuccessMsg{PeersID: ch.remoteID}))
+	}
+	return ch.conn.write(ssh.Marshal(channelRequestFailureMsg{PeersID: ch.remoteID}))
+}
+
+// Stderr returns the extended data stream of this channel
+func (ch *Channel) Stderr() io.ReadWriter {
+	return stderr{ch}
+}
+
+type stderr struct{ ch *Channel }
+
+func (s stderr) Read(data []byte) (int, error) {
+	n, err := s.ch.stderr.Read(data)
+	s.ch.adjustWindow(n)
+	return n, err
+}
+
+func (s stderr) Write(data []byte) (int, error) {
+	return s.ch.writeData(data, true)
+}
+
+// buffer holds data received on a channel
+type buffer struct {
+	m    sync.Mutex
+	c    *sync.Cond
+	data []byte
+	eof  bool
+}
+
+func newBuffer() *buffer {
+	b := &buffer{}
+	b.c = sync.NewCond(&b.m)
+	return b
+}
+
+func (b *buffer) write(data []byte) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.data = append(b.data, data...)
+	b.c.Broadcast()
+}
+
+func (b *buffer) setEOF() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.eof = true
+	b.c.Broadcast()
+}
+
+// Read reads data from the buffer, blocking until data is available or EOF was reached.
+func (b *buffer) Read(data []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	for len(b.data) == 0 && !b.eof {
+		b.c.Wait()
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(data, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+// window is the number of bytes the peer is willing to receive on a channel
+type window struct {
+	m      sync.Mutex
+	c      *sync.Cond
+	size   uint32
+	closed bool
+}
+
+func newWindow() *window {
+	w := &window{}
+	w.c = sync.NewCond(&w.m)
+	return w
+}
+
+func (w *window) add(n uint32) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.size += n
+	w.c.Broadcast()
+}
+
+func (w *window) close() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.closed = true
+	w.c.Broadcast()
+}
+
+// reserve reserves up to want bytes of the window.
+// It blocks until at least one byte is available, or the window is closed.
+func (w *window) reserve(want uint32) (uint32, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for w.size == 0 && !w.closed {
+		w.c.Wait()
+	}
+	if w.closed {
+		return 0, io.EOF
+	}
+
+	if want > w.size {
+		want = w.size
+	}
+	w.size -= want
+	return want, nil
+}
@@ -0,0 +1,133 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newConnPair creates a pair of connected Conns
+func newConnPair() (*Conn, *Conn) {
+	left, right := net.Pipe()
+	return NewConn(left, Metadata{User: "left"}), NewConn(right, Metadata{User: "right"})
+}
+
+func TestConn_channel(t *testing.T) {
+	client, server := newConnPair()
+	defer client.Close()
+	defer server.Close()
+
+	// echo server that reports the exit status in a request
+	go func() {
+		chans, _ := server.Incoming()
+		for nc := range chans {
+			if nc.ChannelType() != "echo" {
+				nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+				continue
+			}
+			ch, reqs, err := nc.AcceptRaw()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			go func() {
+				for req := range reqs {
+					req.Reply(req.Type == "ping", nil)
+				}
+			}()
+			extra := nc.ExtraData()
+			go func() {
+				io.Copy(ch.Stderr(), bytes.NewReader(extra))
+				io.Copy(ch, ch)
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{42}))
+				ch.Close()
+			}()
+		}
+	}()
+
+	if _, _, err := client.OpenChannel("unknown", nil); err == nil {
+		t.Fatal("OpenChannel() of unknown type did not fail")
+	}
+
+	ch, reqs, err := client.OpenChannel("echo", []byte("extra"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := ch.SendRequest("ping", true, nil)
+	if !ok || err != nil {
+		t.Errorf("SendRequest() = %v, %v, want true, nil", ok, err)
+	}
+
+	// send more data than fits into the initial window
+	want := bytes.Repeat([]byte("0123456789"), channelWindowSize/5)
+	go func() {
+		ch.Write(want)
+		ch.CloseWrite()
+	}()
+
+	got, err := io.ReadAll(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("received %d bytes, want %d", len(got), len(want))
+	}
+
+	stderr, err := io.ReadAll(ch.Stderr())
+	if err != nil || string(stderr) != "extra" {
+		t.Errorf("Stderr() = %q, %v, want %q, nil", stderr, err, "extra")
+	}
+
+	req, ok := <-reqs
+	if !ok || req.Type != "exit-status" {
+		t.Fatalf("expected exit-status request, got %v", req)
+	}
+	if _, ok := <-reqs; ok {
+		t.Error("requests not closed after channel was closed")
+	}
+}
+
+func TestConn_SendRequest(t *testing.T) {
+	client, server := newConnPair()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_, reqs := server.Incoming()
+		for req := range reqs {
+			req.Reply(req.Type == "hello", []byte(req.Type))
+		}
+	}()
+
+	for _, tt := range []struct {
+		name string
+		ok   bool
+	}{
+		{"hello", true},
+		{"world", false},
+	} {
+		ok, payload, err := client.SendRequest(tt.name, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok || string(payload) != tt.name {
+			t.Errorf("SendRequest(%q) = %v, %q, want %v, %q", tt.name, ok, payload, tt.ok, tt.name)
+		}
+	}
+}
+
+func TestConn_Close(t *testing.T) {
+	client, server := newConnPair()
+	server.Close()
+
+	if err := client.Wait(); err == nil {
+		t.Error("Wait() returned nil error after peer closed")
+	}
+	if _, _, err := client.OpenChannel("session", nil); err == nil {
+		t.Error("OpenChannel() did not fail after peer closed")
+	}
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package mux
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+var errNoFdpass = errors.New("mux: client did not pass a file descriptor")
+
+// receiveFile receives a single file descriptor passed over conn
+func receiveFile(conn *net.UnixConn) (*os.File, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		fds, err := syscall.ParseUnixRights(&msg)
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		for _, extra := range fds[1:] {
+			syscall.Close(extra)
+		}
+		return os.NewFile(uintptr(fds[0]), "mux"), nil
+	}
+	return nil, errNoFdpass
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package mux
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+var errNoFdpass = errors.New("mux: file descriptor passing is not supported on this platform")
+
+// receiveFile receives a single file descriptor passed over conn
+func receiveFile(conn *net.UnixConn) (*os.File, error) {
+	return nil, errNoFdpass
+}
@@ -0,0 +1,145 @@
+// Package mux implements the OpenSSH connection multiplexing protocol.
+//
+// A master holds an established ssh connection and listens on a unix socket, the ControlPath.
+// Clients connect to the socket to open new sessions or forwardings using the connection of the master.
+// See PROTOCOL.mux in the OpenSSH sources for a description of the protocol.
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Version is the version of the multiplexing protocol implemented by this package
+const Version = 4
+
+// message types of the multiplexing protocol
+const (
+	msgHello = 0x00000001
+
+	msgNewSession    = 0x10000002
+	msgAliveCheck    = 0x10000004
+	msgTerminate     = 0x10000005
+	msgOpenForward   = 0x10000006
+	msgCloseForward  = 0x10000007
+	msgNewStdioFwd   = 0x10000008
+	msgStopListening = 0x10000009
+	msgCancelForward = 0x1000000a
+	msgProxy         = 0x1000000f
+
+	msgOK               = 0x80000001
+	msgPermissionDenied = 0x80000002
+	msgFailure          = 0x80000003
+	msgExitMessage      = 0x80000004
+	msgAlive            = 0x80000005
+	msgSessionOpened    = 0x80000006
+	msgRemotePort       = 0x80000007
+	msgTTYAllocFail     = 0x80000008
+	msgProxyOK          = 0x8000000f
+)
+
+// maxPacket is the maximal size of a packet accepted by this package
+const maxPacket = 256 * 1024
+
+var errPacketTooLarge = errors.New("mux: packet too large")
+
+// readPacket reads a length-prefixed packet from r
+func readPacket(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxPacket {
+		return nil, errPacketTooLarge
+	}
+
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// writePacket writes a length-prefixed packet to w
+func writePacket(w io.Writer, packet []byte) error {
+	buffer := make([]byte, 4+len(packet))
+	binary.BigEndian.PutUint32(buffer, uint32(len(packet)))
+	copy(buffer[4:], packet)
+
+	_, err := w.Write(buffer)
+	return err
+}
+
+// message is a message of the multiplexing protocol
+type message struct {
+	data []byte
+}
+
+// newMessage creates a new message of the given type
+func newMessage(typ uint32) *message {
+	msg := &message{}
+	msg.putUint32(typ)
+	return msg
+}
+
+func (msg *message) putUint32(value uint32) {
+	var buffer [4]byte
+	binary.BigEndian.PutUint32(buffer[:], value)
+	msg.data = append(msg.data, buffer[:]...)
+}
+
+func (msg *message) putString(value string) {
+	msg.putUint32(uint32(len(value)))
+	msg.data = append(msg.data, value...)
+}
+
+var errShortMessage = errors.New("mux: message too short")
+
+// reader reads values from a received message.
+// Once an error occurs, all further reads return zero values.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errShortMessage
+		return 0
+	}
+	value := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return value
+}
+
+func (r *reader) string() string {
+	length := r.uint32()
+	if r.err != nil || uint32(len(r.data)) < length {
+		r.err = errShortMessage
+		return ""
+	}
+	value := string(r.data[:length])
+	r.data = r.data[length:]
+	return value
+}
+
+// empty checks if all data of the message has been read
+func (r *reader) empty() bool {
+	return len(r.data) == 0
+}
+
+// ErrRefused is returned when a master refuses or fails a request.
+type ErrRefused struct {
+	PermissionDenied bool
+	Reason           string
+}
+
+func (err ErrRefused) Error() string {
+	if err.PermissionDenied {
+		return "mux: permission denied: " + err.Reason
+	}
+	return "mux: request failed: " + err.Reason
+}
@@ -0,0 +1,487 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Server is a master sharing an ssh client with the clients connecting to it.
+//
+// Server supports checking if it is alive, terminating it, stopping it from listening,
+// new sessions, stdio forwardings and proxy mode.
+// Opening port forwardings is not supported.
+type Server struct {
+	// Client is the shared ssh client
+	Client *ssh.Client
+
+	// Confirm is called before a client may open a session, a forwarding, use proxy mode, or terminate the master.
+	// description describes the request.
+	// When nil, all requests are allowed.
+	Confirm func(description string) bool
+
+	// Terminate is called when a client requested the master to terminate.
+	// When nil, terminate requests are refused.
+	Terminate func()
+
+	// Active is called whenever the number of connected clients changes.
+	Active func(clients int)
+
+	m        sync.Mutex
+	listener net.Listener
+	closed   bool
+	clients  int
+	sessions uint32 // id of the last session
+}
+
+// Serve accepts clients on listener, until listener is closed.
+func (server *Server) Serve(listener net.Listener) error {
+	server.m.Lock()
+	if server.closed {
+		server.m.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	server.listener = listener
+	server.m.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		server.addClients(1)
+		go func() {
+			defer server.addClients(-1)
+			defer conn.Close()
+
+			server.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops the server from listening.
+// Clients that are already connected are not affected.
+func (server *Server) Close() error {
+	server.m.Lock()
+	defer server.m.Unlock()
+
+	server.closed = true
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Close()
+}
+
+func (server *Server) addClients(delta int) {
+	server.m.Lock()
+	server.clients += delta
+	clients := server.clients
+	server.m.Unlock()
+
+	if server.Active != nil {
+		server.Active(clients)
+	}
+}
+
+func (server *Server) nextSession() uint32 {
+	server.m.Lock()
+	defer server.m.Unlock()
+
+	server.sessions++
+	return server.sessions
+}
+
+func (server *Server) confirm(description string) bool {
+	return server.Confirm == nil || server.Confirm(description)
+}
+
+var errUnexpectedHello = errors.New("mux: expected hello message")
+
+// serveConn handles requests of a single client
+func (server *Server) serveConn(conn net.Conn) error {
+	hello := newMessage(msgHello)
+	hello.putUint32(Version)
+	if err := writePacket(conn, hello.data); err != nil {
+		return err
+	}
+
+	packet, err := readPacket(conn)
+	if err != nil {
+		return err
+	}
+	r := reader{data: packet}
+	if r.uint32() != msgHello || r.err != nil {
+		return errUnexpectedHello
+	}
+
+	for {
+		packet, err := readPacket(conn)
+		if err != nil {
+			return err
+		}
+
+		r := reader{data: packet}
+		typ := r.uint32()
+		id := r.uint32()
+		if r.err != nil {
+			return r.err
+		}
+
+		switch typ {
+		case msgAliveCheck:
+			reply := newMessage(msgAlive)
+			reply.putUint32(id)
+			reply.putUint32(uint32(os.Getpid()))
+			err = writePacket(conn, reply.data)
+		case msgTerminate:
+			if server.Terminate == nil || !server.confirm("terminate master") {
+				err = refuse(conn, id, true, "terminate not permitted")
+				break
+			}
+			if err = ok(conn, id); err == nil {
+				server.Terminate()
+			}
+		case msgStopListening:
+			if !server.confirm("stop listening") {
+				err = refuse(conn, id, true, "stop listening not permitted")
+				break
+			}
+			server.Close()
+			err = ok(conn, id)
+		case msgProxy:
+			if !server.confirm("proxy") {
+				err = refuse(conn, id, true, "proxy not permitted")
+				break
+			}
+			reply := newMessage(msgProxyOK)
+			reply.putUint32(id)
+			if err := writePacket(conn, reply.data); err != nil {
+				return err
+			}
+			return server.serveProxy(conn)
+		case msgNewSession:
+			return server.serveSession(conn, id, &r)
+		case msgNewStdioFwd:
+			return server.serveStdioFwd(conn, id, &r)
+		default:
+			err = refuse(conn, id, false, fmt.Sprintf("unsupported request %#x", typ))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ok sends an ok reply to request id
+func ok(conn net.Conn, id uint32) error {
+	reply := newMessage(msgOK)
+	reply.putUint32(id)
+	return writePacket(conn, reply.data)
+}
+
+// refuse sends a failure or permission denied reply to request id
+func refuse(conn net.Conn, id uint32, denied bool, reason string) error {
+	typ := uint32(msgFailure)
+	if denied {
+		typ = msgPermissionDenied
+	}
+	reply := newMessage(typ)
+	reply.putUint32(id)
+	reply.putString(reason)
+	return writePacket(conn, reply.data)
+}
+
+// sessionOpened sends a session opened reply to request id
+func sessionOpened(conn net.Conn, id uint32, session uint32) error {
+	reply := newMessage(msgSessionOpened)
+	reply.putUint32(id)
+	reply.putUint32(session)
+	return writePacket(conn, reply.data)
+}
+
+// receiveFiles receives n files passed by the client over conn
+func receiveFiles(conn net.Conn, n int) ([]*os.File, error) {
+	unix, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errNoFdpass
+	}
+
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		file, err := receiveFile(unix)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// serveSession serves a new session request.
+// r holds the remainder of the request.
+func (server *Server) serveSession(conn net.Conn, id uint32, r *reader) error {
+	// flags are encoded as uint32 by OpenSSH
+	r.string() // reserved
+	wantTTY := r.uint32() != 0
+	r.uint32() // x11 forwarding
+	r.uint32() // agent forwarding
+	subsystem := r.uint32() != 0
+	r.uint32() // escape char
+	terminal := r.string()
+	command := r.string()
+
+	var env []string
+	for r.err == nil && !r.empty() {
+		env = append(env, r.string())
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	files, err := receiveFiles(conn, 3)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+
+	if !server.confirm("new session: " + command) {
+		return refuse(conn, id, true, "session not permitted")
+	}
+
+	session, err := server.Client.NewSession()
+	if err != nil {
+		return refuse(conn, id, false, err.Error())
+	}
+	defer session.Close()
+
+	for _, variable := range env {
+		name, value, ok := cut(variable, "=")
+		if ok {
+			session.Setenv(name, value)
+		}
+	}
+
+	var ttyFailed bool
+	if wantTTY {
+		width, height, err := term.GetSize(int(files[0].Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		ttyFailed = session.RequestPty(terminal, height, width, ssh.TerminalModes{}) != nil
+	}
+
+	session.Stdin = files[0]
+	session.Stdout = files[1]
+	session.Stderr = files[2]
+
+	switch {
+	case subsystem:
+		err = session.RequestSubsystem(command)
+	case command == "":
+		err = session.Shell()
+	default:
+		err = session.Start(command)
+	}
+	if err != nil {
+		return refuse(conn, id, false, err.Error())
+	}
+
+	sid := server.nextSession()
+	if err := sessionOpened(conn, id, sid); err != nil {
+		return err
+	}
+	if ttyFailed {
+		reply := newMessage(msgTTYAllocFail)
+		reply.putUint32(sid)
+		if err := writePacket(conn, reply.data); err != nil {
+			return err
+		}
+	}
+
+	// close the session when the client goes away
+	go func() {
+		io.Copy(io.Discard, conn)
+		session.Close()
+	}()
+
+	exit := exitStatus(session.Wait())
+
+	reply := newMessage(msgExitMessage)
+	reply.putUint32(sid)
+	reply.putUint32(exit)
+	return writePacket(conn, reply.data)
+}
+
+// exitStatus returns the exit status reported to a client for the result of session.Wait
+func exitStatus(err error) uint32 {
+	if err == nil {
+		return 0
+	}
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) {
+		return uint32(exit.ExitStatus())
+	}
+	return 255
+}
+
+// cut is like strings.Cut
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// serveStdioFwd serves a new stdio forwarding request.
+// r holds the remainder of the request.
+func (server *Server) serveStdioFwd(conn net.Conn, id uint32, r *reader) error {
+	r.string() // reserved
+	host := r.string()
+	port := r.uint32()
+	if r.err != nil {
+		return r.err
+	}
+
+	files, err := receiveFiles(conn, 2)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+
+	address := net.JoinHostPort(host, fmt.Sprint(port))
+	if !server.confirm("stdio forwarding to " + address) {
+		return refuse(conn, id, true, "forwarding not permitted")
+	}
+
+	remote, err := server.Client.Dial("tcp", address)
+	if err != nil {
+		return refuse(conn, id, false, err.Error())
+	}
+	defer remote.Close()
+
+	if err := sessionOpened(conn, id, server.nextSession()); err != nil {
+		return err
+	}
+
+	go func() {
+		io.Copy(remote, files[0])
+		if cw, ok := remote.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	go func() {
+		// stop forwarding when the client goes away
+		io.Copy(io.Discard, conn)
+		remote.Close()
+	}()
+
+	_, err = io.Copy(files[1], remote)
+	return err
+}
+
+// serveProxy serves a client in proxy mode
+func (server *Server) serveProxy(conn net.Conn) error {
+	proxy := NewConn(conn, Metadata{})
+	chans, reqs := proxy.Incoming()
+
+	go func() {
+		for req := range reqs {
+			switch req.Type {
+			case "tcpip-forward", "cancel-tcpip-forward", "streamlocal-forward@openssh.com", "cancel-streamlocal-forward@openssh.com":
+				// forwarded connections would be delivered to the master, not the client
+				req.Reply(false, nil)
+			default:
+				ok, payload, err := server.Client.SendRequest(req.Type, req.WantReply, req.Payload)
+				req.Reply(ok && err == nil, payload)
+			}
+		}
+	}()
+
+	for nc := range chans {
+		go server.proxyChannel(nc)
+	}
+	return proxy.Wait()
+}
+
+// proxyChannel opens the channel requested by a client in proxy mode, and forwards all data and requests.
+func (server *Server) proxyChannel(nc *NewChannel) {
+	remote, remoteReqs, err := server.Client.OpenChannel(nc.ChannelType(), nc.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			nc.Reject(openErr.Reason, openErr.Message)
+		} else {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+
+	local, localReqs, err := nc.AcceptRaw()
+	if err != nil {
+		remote.Close()
+		return
+	}
+
+	// forward everything from the remote to the client, then close the client channel
+	var toLocal sync.WaitGroup
+	toLocal.Add(3)
+	go func() {
+		defer toLocal.Done()
+		io.Copy(local, remote)
+		local.CloseWrite()
+	}()
+	go func() {
+		defer toLocal.Done()
+		io.Copy(local.Stderr(), remote.Stderr())
+	}()
+	go func() {
+		defer toLocal.Done()
+		for req := range remoteReqs {
+			ok, _ := local.SendRequest(req.Type, req.WantReply, req.Payload)
+			req.Reply(ok, nil)
+		}
+	}()
+	go func() {
+		toLocal.Wait()
+		local.Close()
+	}()
+
+	// forward everything from the client to the remote, then close the remote channel
+	var toRemote sync.WaitGroup
+	toRemote.Add(3)
+	go func() {
+		defer toRemote.Done()
+		io.Copy(remote, local)
+		remote.CloseWrite()
+	}()
+	go func() {
+		defer toRemote.Done()
+		io.Copy(remote.Stderr(), local.Stderr())
+	}()
+	go func() {
+		defer toRemote.Done()
+		for req := range localReqs {
+			ok, _ := remote.SendRequest(req.Type, req.WantReply, req.Payload)
+			req.Reply(ok, nil)
+		}
+	}()
+	toRemote.Wait()
+	remote.Close()
+}
@@ -0,0 +1,66 @@
+// Package pattern implements the patterns used in ssh_config files.
+package pattern
+
+import "strings"
+
+// Match checks if value matches pattern.
+// '*' matches any sequence of characters, '?' matches exactly one character.
+func Match(pattern string, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(value); i++ {
+				if Match(pattern, value[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		default:
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
+
+// MatchList checks if value matches the comma-separated list of patterns.
+// Patterns prefixed with '!' are negated, a matching negated pattern causes the list to not match.
+//
+// When fold is true, matching is case-insensitive.
+func MatchList(list string, value string, fold bool) bool {
+	return MatchAny(strings.Split(list, ","), value, fold)
+}
+
+// MatchAny is like MatchList, except that it takes a slice of patterns.
+func MatchAny(patterns []string, value string, fold bool) bool {
+	if fold {
+		value = strings.ToLower(value)
+	}
+
+	var found bool
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if fold {
+			pattern = strings.ToLower(pattern)
+		}
+		if !Match(pattern, value) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		found = true
+	}
+	return found
+}
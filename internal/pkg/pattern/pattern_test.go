@@ -0,0 +1,44 @@
+package pattern
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*.example.com", "host.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"h?st", "host", true},
+		{"h?st", "hst", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMatchList(t *testing.T) {
+	tests := []struct {
+		list  string
+		value string
+		fold  bool
+		want  bool
+	}{
+		{"*.example.com,*.example.org", "host.example.org", false, true},
+		{"*.example.com,!bad.example.com", "bad.example.com", false, false},
+		{"!bad.example.com", "good.example.com", false, false},
+		{"*.EXAMPLE.com", "host.example.COM", true, true},
+		{"*.EXAMPLE.com", "host.example.COM", false, false},
+	}
+	for _, tt := range tests {
+		if got := MatchList(tt.list, tt.value, tt.fold); got != tt.want {
+			t.Errorf("MatchList(%q, %q, %v) = %v, want %v", tt.list, tt.value, tt.fold, got, tt.want)
+		}
+	}
+}
@@ -46,3 +46,13 @@ func Combine[T any](slices ...[]T) (result []T) {
 	}
 	return
 }
+
+// Contains checks if slice contains value.
+func Contains[T comparable](slice []T, value T) bool {
+	for _, element := range slice {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
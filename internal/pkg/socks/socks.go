@@ -0,0 +1,283 @@
+// Package socks implements the server side of the SOCKS4, SOCKS4a and SOCKS5 protocols.
+//
+// Only the CONNECT command is supported.
+// SOCKS5 clients may only use the "no authentication required" method.
+// Domain names sent by clients are passed to the dial function unresolved.
+package socks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// protocol versions
+const (
+	version4 = 0x04
+	version5 = 0x05
+)
+
+// commands
+const (
+	cmdConnect = 0x01
+)
+
+// SOCKS4 reply codes
+const (
+	reply4Granted  = 0x5a
+	reply4Rejected = 0x5b
+)
+
+// SOCKS5 authentication methods
+const (
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xff
+)
+
+// SOCKS5 address types
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// SOCKS5 reply codes
+const (
+	reply5Succeeded           = 0x00
+	reply5GeneralFailure      = 0x01
+	reply5CommandNotSupported = 0x07
+	reply5AtypNotSupported    = 0x08
+)
+
+// Dialer dials the destination requested by a client.
+// address is of the form "host:port", where host may be a domain name.
+type Dialer func(network, address string) (net.Conn, error)
+
+// ErrUnsupportedVersion is returned when a client uses an unknown protocol version
+type ErrUnsupportedVersion struct {
+	Version byte
+}
+
+func (err ErrUnsupportedVersion) Error() string {
+	return fmt.Sprintf("socks: unsupported version %d", err.Version)
+}
+
+// ErrUnsupportedCommand is returned when a client requests a command other than CONNECT
+type ErrUnsupportedCommand struct {
+	Command byte
+}
+
+func (err ErrUnsupportedCommand) Error() string {
+	return fmt.Sprintf("socks: unsupported command %d", err.Command)
+}
+
+var (
+	errNoAcceptableMethod = errors.New("socks: no acceptable authentication method")
+	errUnsupportedAddress = errors.New("socks: unsupported address type")
+)
+
+// Handshake performs the server side of a SOCKS handshake on conn.
+// It reads the request of the client, dials the requested destination using dial, and sends the reply.
+//
+// On success, returns the connection to the destination.
+// The caller is then responsible for forwarding data between conn and the destination.
+// On failure, a failure reply is sent to the client if possible.
+func Handshake(conn net.Conn, dial Dialer) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var remote net.Conn
+	switch version {
+	case version4:
+		remote, err = handshake4(conn, r, dial)
+	case version5:
+		remote, err = handshake5(conn, r, dial)
+	default:
+		return nil, ErrUnsupportedVersion{Version: version}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// clients may send data before receiving the reply
+	if r.Buffered() > 0 {
+		buffered, _ := r.Peek(r.Buffered())
+		if _, err := remote.Write(buffered); err != nil {
+			remote.Close()
+			return nil, err
+		}
+	}
+	return remote, nil
+}
+
+// handshake4 handles a SOCKS4 or SOCKS4a request.
+// The version has already been read.
+func handshake4(conn net.Conn, r *bufio.Reader, dial Dialer) (net.Conn, error) {
+	var header [7]byte // command, port, ip
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if _, err := readString(r); err != nil { // user id
+		return nil, err
+	}
+
+	if header[0] != cmdConnect {
+		reply4(conn, reply4Rejected)
+		return nil, ErrUnsupportedCommand{Command: header[0]}
+	}
+
+	port := binary.BigEndian.Uint16(header[1:3])
+	ip := net.IP(header[3:7])
+
+	// SOCKS4a: an ip of 0.0.0.x with x != 0 indicates a domain name
+	host := ip.String()
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		host = domain
+	}
+
+	remote, err := dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		reply4(conn, reply4Rejected)
+		return nil, err
+	}
+	if err := reply4(conn, reply4Granted); err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return remote, nil
+}
+
+// reply4 sends a SOCKS4 reply with the given code
+func reply4(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{0x00, code, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// readString reads a null-terminated string
+func readString(r *bufio.Reader) (string, error) {
+	value, err := r.ReadString(0x00)
+	if err != nil {
+		return "", err
+	}
+	return value[:len(value)-1], nil
+}
+
+// handshake5 handles a SOCKS5 request.
+// The version has already been read.
+func handshake5(conn net.Conn, r *bufio.Reader, dial Dialer) (net.Conn, error) {
+	// negotiate the authentication method
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	methods := make([]byte, count)
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+
+	method := byte(methodNoAcceptable)
+	for _, m := range methods {
+		if m == methodNoAuth {
+			method = methodNoAuth
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{version5, method}); err != nil {
+		return nil, err
+	}
+	if method == methodNoAcceptable {
+		return nil, errNoAcceptableMethod
+	}
+
+	// read the request
+	var header [4]byte // version, command, reserved, address type
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != version5 {
+		return nil, ErrUnsupportedVersion{Version: header[0]}
+	}
+
+	var host string
+	switch header[3] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if header[3] == atypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	case atypDomain:
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return nil, err
+		}
+		host = string(domain)
+	default:
+		reply5(conn, reply5AtypNotSupported, nil)
+		return nil, errUnsupportedAddress
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return nil, err
+	}
+
+	if header[1] != cmdConnect {
+		reply5(conn, reply5CommandNotSupported, nil)
+		return nil, ErrUnsupportedCommand{Command: header[1]}
+	}
+
+	remote, err := dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))))
+	if err != nil {
+		reply5(conn, reply5GeneralFailure, nil)
+		return nil, err
+	}
+	if err := reply5(conn, reply5Succeeded, remote.LocalAddr()); err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return remote, nil
+}
+
+// reply5 sends a SOCKS5 reply with the given code.
+// When bound is a TCP address, it is sent as the bound address.
+func reply5(conn net.Conn, code byte, bound net.Addr) error {
+	ip := net.IP(net.IPv4zero.To4())
+	port := 0
+	if addr, ok := bound.(*net.TCPAddr); ok && addr.IP != nil {
+		ip, port = addr.IP, addr.Port
+	}
+
+	reply := []byte{version5, code, 0x00}
+	if ip4 := ip.To4(); ip4 != nil {
+		reply = append(reply, atypIPv4)
+		reply = append(reply, ip4...)
+	} else {
+		reply = append(reply, atypIPv6)
+		reply = append(reply, ip.To16()...)
+	}
+	reply = append(reply, byte(port>>8), byte(port))
+
+	_, err := conn.Write(reply)
+	return err
+}
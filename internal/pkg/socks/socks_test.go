@@ -0,0 +1,144 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		request []byte
+		reply   []byte
+		address string // address dialed, empty if no dial should happen
+		wantErr bool
+	}{
+		{
+			name:    "SOCKS4",
+			request: []byte{4, 1, 0, 80, 10, 0, 0, 1, 'u', 0},
+			reply:   []byte{0, 0x5a, 0, 0, 0, 0, 0, 0},
+			address: "10.0.0.1:80",
+		},
+		{
+			name:    "SOCKS4a",
+			request: []byte{4, 1, 1, 187, 0, 0, 0, 1, 0, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0},
+			reply:   []byte{0, 0x5a, 0, 0, 0, 0, 0, 0},
+			address: "example:443",
+		},
+		{
+			name:    "SOCKS4 bind",
+			request: []byte{4, 2, 0, 80, 10, 0, 0, 1, 0},
+			reply:   []byte{0, 0x5b, 0, 0, 0, 0, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS5 ipv4",
+			request: []byte{5, 1, 0, 5, 1, 0, 1, 10, 0, 0, 1, 0, 22},
+			reply:   []byte{5, 0, 5, 0, 0, 1, 0, 0, 0, 0, 0, 0},
+			address: "10.0.0.1:22",
+		},
+		{
+			name:    "SOCKS5 ipv6",
+			request: []byte{5, 1, 0, 5, 1, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 22},
+			reply:   []byte{5, 0, 5, 0, 0, 1, 0, 0, 0, 0, 0, 0},
+			address: "[::1]:22",
+		},
+		{
+			name:    "SOCKS5 domain",
+			request: []byte{5, 2, 2, 0, 5, 1, 0, 3, 4, 'h', 'o', 's', 't', 0, 80},
+			reply:   []byte{5, 0, 5, 0, 0, 1, 0, 0, 0, 0, 0, 0},
+			address: "host:80",
+		},
+		{
+			name:    "SOCKS5 password only",
+			request: []byte{5, 1, 2},
+			reply:   []byte{5, 0xff},
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS5 udp associate",
+			request: []byte{5, 1, 0, 5, 3, 0, 1, 10, 0, 0, 1, 0, 22},
+			reply:   []byte{5, 0, 5, 7, 0, 1, 0, 0, 0, 0, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "unknown version",
+			request: []byte{6},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			var dialed string
+			dial := func(network, address string) (net.Conn, error) {
+				dialed = address
+				local, remote := net.Pipe()
+				go func() {
+					io.Copy(io.Discard, remote)
+					remote.Close()
+				}()
+				return local, nil
+			}
+
+			type result struct {
+				conn net.Conn
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				conn, err := Handshake(server, dial)
+				server.Close()
+				done <- result{conn, err}
+			}()
+
+			go client.Write(tt.request)
+			reply, _ := io.ReadAll(client)
+			res := <-done
+			if res.conn != nil {
+				res.conn.Close()
+			}
+
+			if (res.err != nil) != tt.wantErr {
+				t.Errorf("Handshake() error = %v, wantErr %v", res.err, tt.wantErr)
+			}
+			if dialed != tt.address {
+				t.Errorf("Handshake() dialed %q, want %q", dialed, tt.address)
+			}
+			if !bytes.Equal(reply, tt.reply) {
+				t.Errorf("Handshake() replied %v, want %v", reply, tt.reply)
+			}
+		})
+	}
+}
+
+func TestHandshake_dialError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	errDial := errors.New("dial failed")
+	done := make(chan error, 1)
+	go func() {
+		_, err := Handshake(server, func(network, address string) (net.Conn, error) {
+			return nil, errDial
+		})
+		server.Close()
+		done <- err
+	}()
+
+	go client.Write([]byte{5, 1, 0, 5, 1, 0, 1, 10, 0, 0, 1, 0, 22})
+	reply, _ := io.ReadAll(client)
+
+	if err := <-done; !errors.Is(err, errDial) {
+		t.Errorf("Handshake() error = %v, want %v", err, errDial)
+	}
+	if want := []byte{5, 0, 5, 1, 0, 1, 0, 0, 0, 0, 0, 0}; !bytes.Equal(reply, want) {
+		t.Errorf("Handshake() replied %v, want %v", reply, want)
+	}
+}
@@ -2,6 +2,12 @@ package source
 
 import "github.com/tkw1536/stringreader"
 
+// Combine combines several sources into one.
+// Values are looked up in the sources in order, the first source containing a value is used.
+func Combine(sources ...Source) Source {
+	return csource{sources: sources}
+}
+
 type csource struct {
 	sources []Source
 	aliases []stringreader.Source
@@ -17,6 +23,17 @@ func (c csource) Alias(alias string) stringreader.Source {
 	return c
 }
 
+func (c csource) Match(ctx Context) (stringreader.Source, bool) {
+	var final bool
+	c.aliases = make([]stringreader.Source, len(c.sources))
+	for i, s := range c.sources {
+		var f bool
+		c.aliases[i], f = s.Match(ctx)
+		final = final || f
+	}
+	return c, final
+}
+
 func (c csource) Lookup(key string) (value string, ok bool) {
 	for _, a := range c.aliases {
 		value, ok = a.Lookup(key)
@@ -35,3 +52,41 @@ func (c csource) LookupAll(key string) (values []string, ok bool) {
 	}
 	return nil, false
 }
+
+// Layer layers the values of several passes over a configuration.
+// Single values are taken from the first source containing them.
+// Multiple values are combined from all sources, omitting duplicates.
+func Layer(sources ...stringreader.Source) stringreader.Source {
+	return layer(sources)
+}
+
+type layer []stringreader.Source
+
+func (l layer) Lookup(key string) (value string, ok bool) {
+	for _, s := range l {
+		value, ok = s.Lookup(key)
+		if ok {
+			return
+		}
+	}
+	return "", false
+}
+
+func (l layer) LookupAll(key string) (values []string, ok bool) {
+	seen := make(map[string]struct{})
+	for _, s := range l {
+		all, aok := s.LookupAll(key)
+		if !aok {
+			continue
+		}
+		ok = true
+		for _, value := range all {
+			if _, dup := seen[value]; dup {
+				continue
+			}
+			seen[value] = struct{}{}
+			values = append(values, value)
+		}
+	}
+	return
+}
@@ -0,0 +1,258 @@
+package source
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tkw1536/stringreader"
+)
+
+// FromFile reads the ssh_config file at path into a Source.
+// In contrast to FromSSHConfig, the returned source supports Match blocks.
+//
+// Relative Include paths are resolved relative to dir, and Include paths starting with '~/' relative to home.
+// Typically, dir is ~/.ssh for user configuration files, and /etc/ssh for system configuration files.
+func FromFile(path string, dir string, home string) (Source, error) {
+	f, err := parseFile(path, dir, home, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// FromFileIfExists is like FromFile, except that a non-existing file results in an empty Source.
+func FromFileIfExists(path string, dir string, home string) (Source, error) {
+	f, err := parseFile(path, dir, home, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &file{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Parse parses an ssh_config file from reader into a Source.
+// path is only used in error messages, dir and home are used like in FromFile.
+func Parse(reader io.Reader, path string, dir string, home string) (Source, error) {
+	f, err := parse(reader, path, dir, home, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// maxIncludeDepth is the maximal depth of nested Include directives
+const maxIncludeDepth = 16
+
+// file represents a parsed ssh_config file
+type file struct {
+	entries []entry
+}
+
+// entry is a single line of an ssh_config file
+type entry struct {
+	key   string // lower-case keyword
+	value string
+
+	args     []string // arguments of Host and Match entries
+	includes []*file  // files included by Include entries
+
+	path string
+	line int
+}
+
+// ErrSyntax is an error in an ssh_config file
+type ErrSyntax struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (err ErrSyntax) Error() string {
+	return fmt.Sprintf("%s:%d: %s", err.Path, err.Line, err.Message)
+}
+
+func parseFile(path string, dir string, home string, depth int) (*file, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parse(f, path, dir, home, depth)
+}
+
+func parse(reader io.Reader, path string, dir string, home string, depth int) (*file, error) {
+	var f file
+
+	scanner := bufio.NewScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		e, ok := parseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		e.path = path
+		e.line = number
+
+		if e.value == "" {
+			return nil, ErrSyntax{Path: path, Line: number, Message: fmt.Sprintf("missing argument for %q", e.key)}
+		}
+
+		var err error
+		switch e.key {
+		case "host":
+			e.args, err = splitArgs(e.value)
+		case "match":
+			e.args, err = splitArgs(e.value)
+			if err == nil {
+				err = checkMatch(e.args)
+			}
+		case "include":
+			e.includes, err = parseIncludes(e.value, dir, home, depth)
+		case "proxycommand", "remotecommand", "localcommand", "knownhostscommand":
+			// commands are passed to the shell verbatim
+		default:
+			e.value, err = unquote(e.value)
+		}
+		if err != nil {
+			var syntax ErrSyntax
+			if errors.As(err, &syntax) {
+				return nil, err
+			}
+			return nil, ErrSyntax{Path: path, Line: number, Message: err.Error()}
+		}
+
+		f.entries = append(f.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// parseLine parses a single line into an entry.
+// When the line is empty or a comment, ok is false.
+func parseLine(line string) (e entry, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return e, false
+	}
+
+	// the keyword ends at the first space or '='
+	index := strings.IndexFunc(line, func(r rune) bool {
+		return r == '=' || r == ' ' || r == '\t'
+	})
+	if index < 0 {
+		return entry{key: strings.ToLower(line)}, true
+	}
+	e.key = strings.ToLower(line[:index])
+
+	// the value is separated by whitespace and an optional '='
+	value := strings.TrimLeft(line[index:], " \t")
+	value = strings.TrimPrefix(value, "=")
+	e.value = strings.TrimSpace(value)
+
+	return e, true
+}
+
+var errUnclosedQuote = errors.New("unclosed quote")
+
+// splitArgs splits value into whitespace separated arguments.
+// Arguments may be enclosed in double quotes.
+func splitArgs(value string) (args []string, err error) {
+	var current strings.Builder
+	var quoted, inArg bool
+
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, errUnclosedQuote
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// unquote removes double quotes from value.
+// Values without quotes are returned unchanged.
+func unquote(value string) (string, error) {
+	if !strings.ContainsRune(value, '"') {
+		return value, nil
+	}
+	args, err := splitArgs(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(args, " "), nil
+}
+
+// parseIncludes parses all files included by the Include value
+func parseIncludes(value string, dir string, home string, depth int) ([]*file, error) {
+	if depth >= maxIncludeDepth {
+		return nil, errors.New("maximum Include depth exceeded")
+	}
+
+	patterns, err := splitArgs(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*file
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "~/") {
+			pattern = filepath.Join(home, pattern[2:])
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			f, err := parseFile(match, dir, home, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+func (*file) isSSHSource() {}
+
+func (f *file) Alias(alias string) stringreader.Source {
+	src, _ := f.Match(Context{Host: alias, OriginalHost: alias})
+	return src
+}
+
+func (f *file) Match(ctx Context) (stringreader.Source, bool) {
+	r := resolver{ctx: ctx, values: make(values)}
+	r.file(f, true)
+	return r.values, r.final
+}
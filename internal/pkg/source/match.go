@@ -0,0 +1,277 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/tkw1536/sshost/internal/pkg/pattern"
+)
+
+// Context holds information used to evaluate Host and Match blocks.
+type Context struct {
+	// Host is matched against Host blocks and 'Match host' criteria.
+	// It is typically the host given by the user, or the canonical hostname.
+	Host string
+
+	// OriginalHost is the host as given by the user, matched against 'Match originalhost'.
+	OriginalHost string
+
+	// Hostname is the hostname resolved by an earlier pass, if any.
+	// It takes precedence over any Hostname setting.
+	Hostname string
+
+	// User is the remote user as given by the user or resolved by an earlier pass, if any.
+	// It takes precedence over any User setting.
+	User string
+
+	// LocalUser is the name of the local user.
+	// It is matched against 'Match localuser', and used as remote user when no other user is set.
+	LocalUser string
+
+	// Final indicates that this is the final pass over the configuration.
+	// Only then 'Match final' and 'Match canonical' criteria match.
+	Final bool
+
+	// Exec runs the command of a 'Match exec' criterion and reports if it exited successfully.
+	// When nil, 'Match exec' criteria never match.
+	Exec func(command string, partial Partial) bool
+}
+
+// Partial holds values of a partially resolved configuration.
+//
+// Hostname defaults to the host of the context, User to the local user.
+// Port is empty when it has not been set yet.
+type Partial struct {
+	OriginalHost string
+	Hostname     string
+	User         string
+	Port         string
+}
+
+// values holds the resolved values of a configuration
+type values map[string][]string
+
+func (v values) Lookup(key string) (string, bool) {
+	all, ok := v[strings.ToLower(key)]
+	if !ok {
+		return "", false
+	}
+	return all[0], true
+}
+
+func (v values) LookupAll(key string) ([]string, bool) {
+	all, ok := v[strings.ToLower(key)]
+	return all, ok
+}
+
+// resolver resolves the values of files for a specific context
+type resolver struct {
+	ctx    Context
+	values values
+	final  bool // 'Match final' was encountered
+}
+
+// file resolves all the entries in f.
+// active indicates if entries are active at the beginning of the file.
+func (r *resolver) file(f *file, active bool) {
+	enabled := active
+	for _, e := range f.entries {
+		switch e.key {
+		case "host":
+			active = enabled && r.host(e.args)
+		case "match":
+			active = r.match(e.args, enabled)
+		case "include":
+			if !active {
+				continue
+			}
+			for _, inc := range e.includes {
+				r.file(inc, true)
+			}
+		default:
+			if active {
+				r.values[e.key] = append(r.values[e.key], e.value)
+			}
+		}
+	}
+}
+
+// partial returns the partially resolved configuration
+func (r *resolver) partial() Partial {
+	partial := Partial{
+		OriginalHost: r.ctx.OriginalHost,
+		Hostname:     r.ctx.Host,
+		User:         r.ctx.User,
+	}
+	if r.ctx.Hostname != "" {
+		partial.Hostname = r.ctx.Hostname
+	} else if hostname, ok := r.values.Lookup("hostname"); ok {
+		partial.Hostname = expandHost(hostname, r.ctx.Host)
+	}
+	if user, ok := r.values.Lookup("user"); ok && partial.User == "" {
+		partial.User = user
+	}
+	if partial.User == "" {
+		partial.User = r.ctx.LocalUser
+	}
+	partial.Port, _ = r.values.Lookup("port")
+	return partial
+}
+
+// expandHost expands the '%h' and '%%' tokens in a Hostname value
+func expandHost(value string, host string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '%' && i+1 < len(value) {
+			switch value[i+1] {
+			case 'h':
+				builder.WriteString(host)
+				i++
+				continue
+			case '%':
+				builder.WriteByte('%')
+				i++
+				continue
+			}
+		}
+		builder.WriteByte(value[i])
+	}
+	return builder.String()
+}
+
+// host checks if the patterns of a Host entry match
+func (r *resolver) host(patterns []string) bool {
+	return pattern.MatchAny(patterns, r.ctx.Host, true)
+}
+
+// match checks if the criteria of a Match entry match.
+// enabled indicates if the surrounding context is active, when false never matches.
+func (r *resolver) match(args []string, enabled bool) bool {
+	result := enabled
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negated := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		switch criterion {
+		case "all":
+			continue
+		case "canonical", "final":
+			if criterion == "final" && !negated {
+				r.final = true
+			}
+			if r.ctx.Final == negated {
+				result = false
+			}
+			continue
+		}
+
+		// all other criteria take an argument, checked by checkMatch
+		i++
+		arg := args[i]
+
+		// do not run commands when we already know the result
+		if !result {
+			continue
+		}
+
+		var ok bool
+		switch criterion {
+		case "exec":
+			ok = r.ctx.Exec != nil && r.ctx.Exec(arg, r.partial())
+		case "host":
+			ok = pattern.MatchList(arg, r.partial().Hostname, true)
+		case "originalhost":
+			ok = pattern.MatchList(arg, r.ctx.OriginalHost, true)
+		case "user":
+			ok = pattern.MatchList(arg, r.partial().User, false)
+		case "localuser":
+			ok = pattern.MatchList(arg, r.ctx.LocalUser, false)
+		case "localnetwork":
+			ok = localNetwork(arg)
+		}
+
+		if ok == negated {
+			result = false
+		}
+	}
+	return result
+}
+
+// criteria maps each supported Match criterion to if it takes an argument
+var criteria = map[string]bool{
+	"all":          false,
+	"canonical":    false,
+	"final":        false,
+	"exec":         true,
+	"host":         true,
+	"originalhost": true,
+	"user":         true,
+	"localuser":    true,
+	"localnetwork": true,
+}
+
+// checkMatch checks that the arguments to a Match entry are valid
+func checkMatch(args []string) error {
+	for i := 0; i < len(args); i++ {
+		criterion := strings.TrimPrefix(strings.ToLower(args[i]), "!")
+		hasArg, ok := criteria[criterion]
+		if !ok {
+			return fmt.Errorf("unsupported Match criterion %q", args[i])
+		}
+		if criterion == "all" && (strings.HasPrefix(args[i], "!") || i != len(args)-1) {
+			return errors.New("'all' cannot be combined with other Match criteria")
+		}
+		if !hasArg {
+			continue
+		}
+		i++
+		if i >= len(args) {
+			return fmt.Errorf("missing argument for Match criterion %q", criterion)
+		}
+	}
+	return nil
+}
+
+// localNetworkAddrs returns the addresses of local network interfaces.
+// It is a variable so that it can be replaced in tests.
+var localNetworkAddrs = net.InterfaceAddrs
+
+// localNetwork checks if any local network interface has an address in the comma-separated list of CIDR ranges.
+// Ranges prefixed with '!' are negated.
+func localNetwork(list string) bool {
+	addrs, err := localNetworkAddrs()
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if cidrList(list, ipnet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// cidrList checks if ip is contained in the comma-separated list of CIDR ranges
+func cidrList(list string, ip net.IP) bool {
+	var found bool
+	for _, cidr := range strings.Split(list, ",") {
+		negated := strings.HasPrefix(cidr, "!")
+		_, network, err := net.ParseCIDR(strings.TrimPrefix(cidr, "!"))
+		if err != nil || !network.Contains(ip) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		found = true
+	}
+	return found
+}
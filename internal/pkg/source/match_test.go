@@ -0,0 +1,195 @@
+package source
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+# a comment
+Host example.com
+	User host-user
+	IdentityFile ~/.ssh/example
+
+Host alias
+	Hostname %h.example.org
+
+Match host *.example.org
+	Port 2222
+
+Match originalhost alias user other
+	Port 3333
+
+Match localuser "local*" !host example.com
+	IdentityFile ~/.ssh/local
+
+Match exec "test run"
+	Compression yes
+
+Match localnetwork 10.0.0.0/8
+	ProxyJump jump.example.com
+
+Match final host *.example.org
+	ForwardAgent yes
+
+Match all
+	IdentityFile ~/.ssh/default
+`
+
+func TestMatch(t *testing.T) {
+	src, err := Parse(strings.NewReader(testConfig), "config", "/home/user/.ssh", "/home/user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old func() ([]net.Addr, error)) { localNetworkAddrs = old }(localNetworkAddrs)
+	localNetworkAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{&net.IPNet{IP: net.IPv4(10, 1, 2, 3), Mask: net.CIDRMask(8, 32)}}, nil
+	}
+
+	exec := func(command string, partial Partial) bool {
+		return command == "test run" && partial.Port == "3333" && partial.User == "other"
+	}
+
+	tests := []struct {
+		name      string
+		ctx       Context
+		want      map[string][]string
+		wantFinal bool
+	}{
+		{
+			name: "example.com",
+			ctx:  Context{Host: "example.com", OriginalHost: "example.com", LocalUser: "localuser"},
+			want: map[string][]string{
+				"User":         {"host-user"},
+				"IdentityFile": {"~/.ssh/example", "~/.ssh/default"},
+				"ProxyJump":    {"jump.example.com"},
+			},
+			wantFinal: true,
+		},
+		{
+			name: "alias",
+			ctx:  Context{Host: "alias", OriginalHost: "alias", LocalUser: "localuser"},
+			want: map[string][]string{
+				"Hostname":     {"%h.example.org"},
+				"Port":         {"2222"},
+				"IdentityFile": {"~/.ssh/local", "~/.ssh/default"},
+				"ProxyJump":    {"jump.example.com"},
+			},
+			wantFinal: true,
+		},
+		{
+			name: "alias with user",
+			ctx:  Context{Host: "alias", OriginalHost: "alias", User: "other", LocalUser: "me"},
+			want: map[string][]string{
+				"Hostname":     {"%h.example.org"},
+				"Port":         {"2222", "3333"},
+				"IdentityFile": {"~/.ssh/default"},
+				"ProxyJump":    {"jump.example.com"},
+			},
+			wantFinal: true,
+		},
+		{
+			name: "final alias",
+			ctx:  Context{Host: "alias.example.org", OriginalHost: "alias", Hostname: "alias.example.org", Final: true, LocalUser: "me"},
+			want: map[string][]string{
+				"Port":         {"2222"},
+				"IdentityFile": {"~/.ssh/default"},
+				"ProxyJump":    {"jump.example.com"},
+				"ForwardAgent": {"yes"},
+			},
+			wantFinal: true,
+		},
+		{
+			name: "exec",
+			ctx:  Context{Host: "other", OriginalHost: "alias", LocalUser: "me", User: "other", Exec: exec},
+			want: map[string][]string{
+				"Port":         {"3333"},
+				"Compression":  {"yes"},
+				"IdentityFile": {"~/.ssh/default"},
+				"ProxyJump":    {"jump.example.com"},
+			},
+			wantFinal: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotFinal := src.Match(tt.ctx)
+			if gotFinal != tt.wantFinal {
+				t.Errorf("Match() final = %v, want %v", gotFinal, tt.wantFinal)
+			}
+			for key, want := range tt.want {
+				values, _ := got.LookupAll(key)
+				if !reflect.DeepEqual(values, want) {
+					t.Errorf("Match() %s = %v, want %v", key, values, want)
+				}
+			}
+			for _, key := range []string{"User", "Hostname", "Port", "Compression", "ForwardAgent", "ProxyJump"} {
+				if _, ok := tt.want[key]; ok {
+					continue
+				}
+				if value, ok := got.Lookup(key); ok {
+					t.Errorf("Match() %s = %q, want unset", key, value)
+				}
+			}
+		})
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, config := range []string{
+		"Host",
+		"Match unknown value",
+		"Match host",
+		"Match all host example.com",
+		`Match exec "unclosed`,
+	} {
+		if _, err := Parse(strings.NewReader(config), "config", "", ""); err == nil {
+			t.Errorf("Parse(%q) did not return an error", config)
+		}
+	}
+}
+
+func TestParse_quotes(t *testing.T) {
+	tests := []struct {
+		config string
+		key    string
+		want   string
+	}{
+		{"IdentityFile \"~/my key\"\n", "IdentityFile", "~/my key"},
+		{"IdentityFile=\"~/.ssh/id_ed25519\"\n", "IdentityFile", "~/.ssh/id_ed25519"},
+		{"SendEnv \"LANG\" LC_*\n", "SendEnv", "LANG LC_*"},
+		{"User plain\n", "User", "plain"},
+		{"ProxyCommand nc \"%h\" %p\n", "ProxyCommand", "nc \"%h\" %p"},
+	}
+	for _, tt := range tests {
+		src, err := Parse(strings.NewReader(tt.config), "config", "", "")
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error %v", tt.config, err)
+		}
+		got, _ := src.Alias("example.com").Lookup(tt.key)
+		if got != tt.want {
+			t.Errorf("Parse(%q) %s = %q, want %q", tt.config, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestParse_includeHome(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, "included"), []byte("User included\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := Parse(strings.NewReader("Include ~/included\n"), "config", filepath.Join(home, ".ssh"), home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := src.Alias("example.com").Lookup("User"); got != "included" {
+		t.Errorf("User = %q, want %q", got, "included")
+	}
+}
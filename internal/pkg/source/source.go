@@ -5,13 +5,20 @@ import "github.com/tkw1536/stringreader"
 // Source is a source of configuration values.
 //
 // All Source types are defined in this package.
-// New Sources can be created using NewSourceMap, FromSSHConfig and FromUserSettings.
+// New Sources can be created using NewSourceMap, FromSSHConfig, FromUserSettings and FromFile.
 // Sources can be combined using Combine.
 type Source interface {
 	// Alias returns source for a specific alias
 	//
 	// When an alias does not exist, should return default values.
 	Alias(alias string) stringreader.Source
+
+	// Match returns source for a specific context, evaluating both Host and Match blocks.
+	// final indicates that a 'Match final' block was encountered, and another pass with ctx.Final set should be made.
+	//
+	// Sources that do not support Match blocks return Alias(ctx.Host).
+	Match(ctx Context) (src stringreader.Source, final bool)
+
 	isSSHSource()
 }
 
@@ -30,6 +37,10 @@ func (m smap) Alias(alias string) stringreader.Source {
 	}
 }
 
+func (m smap) Match(ctx Context) (stringreader.Source, bool) {
+	return m.Alias(ctx.Host), false
+}
+
 func (m smap) Get(key string) (value string, ok bool) {
 	value, ok = m[key]
 	return
@@ -26,6 +26,10 @@ func (config sshConfig) Alias(alias string) stringreader.Source {
 	return config
 }
 
+func (config sshConfig) Match(ctx Context) (stringreader.Source, bool) {
+	return config.Alias(ctx.Host), false
+}
+
 func (config sshConfig) Lookup(key string) (value string, ok bool) {
 	if !config.aliasSet {
 		return "", false
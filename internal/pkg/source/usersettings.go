@@ -25,6 +25,10 @@ func (settings sshUserSettings) Alias(alias string) stringreader.Source {
 	return settings
 }
 
+func (settings sshUserSettings) Match(ctx Context) (stringreader.Source, bool) {
+	return settings.Alias(ctx.Host), false
+}
+
 func (settings sshUserSettings) Lookup(key string) (value string, ok bool) {
 	if !settings.aliasSet {
 		return "", false
@@ -0,0 +1,117 @@
+package sshost
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveRequest is the name of global requests sent to check if a server is alive
+const keepaliveRequest = "keepalive@openssh.com"
+
+// serverAlive monitors a connection to a server using keepalive requests.
+//
+// It wraps the underlying connection, so that once the server timed out reading from it returns an ErrServerAliveTimeout.
+// This error is then reported by the Wait method of the client using the connection.
+type serverAlive struct {
+	net.Conn
+
+	address  string
+	interval time.Duration
+	countMax uint64
+
+	m       sync.Mutex
+	timeout error // set once the server did not reply in time
+}
+
+// newServerAlive creates a new serverAlive monitoring conn, as configured in cfg.
+func newServerAlive(conn net.Conn, cfg Config) *serverAlive {
+	return &serverAlive{
+		Conn: conn,
+
+		address:  net.JoinHostPort(cfg.Hostname, strconv.FormatUint(uint64(cfg.Port), 10)),
+		interval: cfg.ServerAliveInterval,
+		countMax: cfg.ServerAliveCountMax,
+	}
+}
+
+func (alive *serverAlive) Read(b []byte) (int, error) {
+	n, err := alive.Conn.Read(b)
+	if err != nil {
+		alive.m.Lock()
+		if alive.timeout != nil {
+			err = alive.timeout
+		}
+		alive.m.Unlock()
+	}
+	return n, err
+}
+
+// run sends keepalive requests to client, until the client is closed.
+// When ServerAliveCountMax requests in a row have not been answered, closes stack.
+// When ServerAliveCountMax is zero, the connection is never closed.
+func (alive *serverAlive) run(client *ssh.Client, stack *closer.Stack) {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+
+	replies := make(chan struct{}, 1)
+
+	ticker := time.NewTicker(alive.interval)
+	defer ticker.Stop()
+
+	var missed uint64
+	for {
+		select {
+		case <-done:
+			return
+		case <-replies:
+			missed = 0
+		case <-ticker.C:
+			if alive.countMax != 0 && missed >= alive.countMax {
+				alive.m.Lock()
+				alive.timeout = ErrServerAliveTimeout{Address: alive.address, Interval: alive.interval, Count: missed}
+				alive.m.Unlock()
+
+				stack.Close()
+				return
+			}
+
+			missed++
+			go func() {
+				// any reply, even a failure, means that the server is alive
+				if _, _, err := client.SendRequest(keepaliveRequest, true, nil); err != nil {
+					return
+				}
+				select {
+				case replies <- struct{}{}:
+				default:
+				}
+			}()
+		}
+	}
+}
+
+// ErrServerAliveTimeout is reported by a client when the server did not reply to ServerAliveCountMax keepalive requests.
+// The connection to the server is closed.
+type ErrServerAliveTimeout struct {
+	Address  string
+	Interval time.Duration
+	Count    uint64 // number of unanswered requests
+}
+
+func (err ErrServerAliveTimeout) Error() string {
+	return fmt.Sprintf("server %s did not reply to %d keepalive requests sent every %s", err.Address, err.Count, err.Interval)
+}
+
+// Timeout indicates that this error is a timeout
+func (ErrServerAliveTimeout) Timeout() bool {
+	return true
+}
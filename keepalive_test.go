@@ -0,0 +1,146 @@
+package sshost
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+	"golang.org/x/crypto/ssh"
+)
+
+// newSilentClient returns a client connected to a server that never replies to global requests.
+// The returned channel receives every global request the server got.
+// Because the client waits for a reply to each request, at most one request is sent at a time.
+func newSilentClient(t *testing.T) (*ssh.Client, net.Conn, <-chan *ssh.Request) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	requests := make(chan *ssh.Request, 100)
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go func() {
+			for newChannel := range chans {
+				newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			}
+		}()
+		for req := range reqs {
+			requests <- req
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, listener.Addr().String(), &ssh.ClientConfig{
+		User:            "user",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+
+	return client, conn, requests
+}
+
+func TestServerAlive_run(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		client, conn, _ := newSilentClient(t)
+
+		alive := &serverAlive{Conn: conn, address: "example.com:22", interval: 10 * time.Millisecond, countMax: 2}
+
+		done := make(chan struct{})
+		stack := closer.NewStack(closer.NewCloser(func() error {
+			close(done)
+			return client.Close()
+		}))
+		go alive.run(client, stack)
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not close the stack")
+		}
+
+		var timeout ErrServerAliveTimeout
+		if _, err := alive.Read(make([]byte, 1)); !errors.As(err, &timeout) {
+			t.Fatalf("Read() error = %v, want ErrServerAliveTimeout", err)
+		}
+		if timeout.Count != 2 {
+			t.Errorf("ErrServerAliveTimeout.Count = %d, want 2", timeout.Count)
+		}
+	})
+
+	t.Run("zero count max", func(t *testing.T) {
+		client, conn, requests := newSilentClient(t)
+
+		alive := &serverAlive{Conn: conn, address: "example.com:22", interval: 10 * time.Millisecond, countMax: 0}
+
+		closed := make(chan struct{})
+		stack := closer.NewStack(closer.NewCloser(func() error {
+			close(closed)
+			return nil
+		}))
+
+		stopped := make(chan struct{})
+		go func() {
+			alive.run(client, stack)
+			close(stopped)
+		}()
+
+		select {
+		case <-requests:
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not send a keepalive request")
+		}
+
+		// wait well past where a non-zero count would have given up
+		select {
+		case <-closed:
+			t.Fatal("run closed the stack")
+		case <-time.After(20 * alive.interval):
+		}
+
+		client.Close()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not return after the client was closed")
+		}
+		select {
+		case <-closed:
+			t.Fatal("run closed the stack")
+		default:
+		}
+	})
+}
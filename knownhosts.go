@@ -0,0 +1,290 @@
+package sshost
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var knownHostsFlags = expand.Flags{
+	Environment: true,
+	Tilde:       true,
+	Tokens:      "%CdhijkLlnpru",
+}
+
+// UserKnownHostsFile returns the expanded UserKnownHostsFile setting of this profile.
+func (profile *Profile) UserKnownHostsFile() ([]string, error) {
+	return profile.knownHostsFiles(profile.config.UserKnownHostsFile)
+}
+
+// GlobalKnownHostsFile returns the expanded GlobalKnownHostsFile setting of this profile.
+func (profile *Profile) GlobalKnownHostsFile() ([]string, error) {
+	return profile.knownHostsFiles(profile.config.GlobalKnownHostsFile)
+}
+
+// knownHostsFiles expands the provided known_hosts files.
+// The special value "none" is skipped.
+func (profile *Profile) knownHostsFiles(files []string) ([]string, error) {
+	result := make([]string, 0, len(files))
+	ex := profile.expander()
+	for _, file := range files {
+		if file == "none" {
+			continue
+		}
+		name, err := ex.Expand(file, knownHostsFlags)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+// HostKeyCallback returns a callback that verifies host keys against the known_hosts files of this profile.
+// Files that do not exist are skipped.
+//
+// Unknown and changed host keys are handled according to the StrictHostKeyChecking setting.
+// A changed host key results in an error of type ErrHostKeyChanged, an unknown one in an error of type ErrHostKeyUnknown.
+//
+// When the profile has a HostKeyFingerprint, only keys matching it are accepted.
+//
+// Host certificates are verified against @cert-authority entries, see hostCertCallback.
+// Keys revoked by @revoked entries or the RevokedHostKeys file result in an error of type ErrHostKeyRevoked.
+func (profile *Profile) HostKeyCallback() (ssh.HostKeyCallback, error) {
+	user, err := profile.UserKnownHostsFile()
+	if err != nil {
+		return nil, err
+	}
+	global, err := profile.GlobalKnownHostsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(user)+len(global))
+	for _, file := range append(user, global...) {
+		_, err := os.Stat(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	check, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := profile.RevokedHostKeys()
+	if err != nil {
+		return nil, err
+	}
+	markers, err := readHostMarkers(files, revoked)
+	if err != nil {
+		return nil, err
+	}
+
+	var callback ssh.HostKeyCallback
+	if profile.config.HostKeyFingerprint != "" {
+		callback, err = profile.pinnedHostKeyCallback(check)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		callback = profile.knownHostKeyCallback(check, user)
+	}
+	return profile.hostCertCallback(callback, markers), nil
+}
+
+// knownHostKeyCallback returns a callback that checks host keys using check.
+// Unknown keys are added to the first writable file in user.
+func (profile *Profile) knownHostKeyCallback(check ssh.HostKeyCallback, user []string) ssh.HostKeyCallback {
+	mode := profile.config.StrictHostKeyChecking
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		// a known key of the same type means that the key has changed
+		for _, want := range keyErr.Want {
+			if want.Key.Type() != key.Type() {
+				continue
+			}
+			if mode.AllowsChanged() {
+				return nil
+			}
+			return ErrHostKeyChanged{
+				Hostname:       hostname,
+				OldFingerprint: ssh.FingerprintSHA256(want.Key),
+				NewFingerprint: ssh.FingerprintSHA256(key),
+				File:           want.Filename,
+				Line:           want.Line,
+			}
+		}
+
+		// the key is unknown
+		switch {
+		case mode.AddsUnknown():
+			return addKnownHost(user, hostname, key)
+		case mode == StrictHostKeyCheckingAsk:
+			ok, err := profile.env.Auth.askHostKey(hostname, key)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return addKnownHost(user, hostname, key)
+			}
+		}
+		return ErrHostKeyUnknown{
+			Hostname:    hostname,
+			Fingerprint: ssh.FingerprintSHA256(key),
+		}
+	}
+}
+
+// pinnedHostKeyCallback returns a callback that only accepts keys matching the HostKeyFingerprint of this profile.
+// Matching keys are accepted even if they are unknown or differ from the known keys.
+// check is used to reject revoked keys.
+func (profile *Profile) pinnedHostKeyCallback(check ssh.HostKeyCallback) (ssh.HostKeyCallback, error) {
+	fp, ok := parseFingerprint(profile.config.HostKeyFingerprint)
+	if !ok {
+		return nil, NewErrField(nil, "HostKeyFingerprint")
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if !fp.Matches(key) {
+			return ErrHostKeyMismatch{
+				Hostname:    hostname,
+				Expected:    profile.config.HostKeyFingerprint,
+				Fingerprint: ssh.FingerprintSHA256(key),
+			}
+		}
+
+		err := check(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			return nil
+		}
+		return err
+	}, nil
+}
+
+// addKnownHost appends key for hostname to the first file in files that can be written to.
+func addKnownHost(files []string, hostname string, key ssh.PublicKey) error {
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+
+	var err error
+	for _, file := range files {
+		if err = appendLine(file, line); err == nil {
+			return nil
+		}
+	}
+	if err == nil {
+		err = errNoKnownHostsFile
+	}
+	return err
+}
+
+var errNoKnownHostsFile = errors.New("no UserKnownHostsFile to add host key to")
+
+// appendLine appends a line to file, creating it and its parent directory if needed.
+func appendLine(file string, line string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// make sure that we start on a new line
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = "\n" + line
+		}
+	}
+
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+// askHostKey asks the user if the unknown key for hostname should be trusted.
+func (m AuthEnv) askHostKey(hostname string, key ssh.PublicKey) (bool, error) {
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	m.print(fmt.Sprintf("The authenticity of host %q can't be established.", hostname), true)
+	m.print(fmt.Sprintf("%s key fingerprint is %s.", key.Type(), fingerprint), true)
+	m.print("Are you sure you want to continue connecting (yes/no/[fingerprint])? ", false)
+
+	for {
+		answer, err := m.readOpen()
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "yes":
+			return true, nil
+		case "no":
+			return false, nil
+		}
+		if answer == fingerprint {
+			return true, nil
+		}
+		m.print("Please type 'yes', 'no' or the fingerprint: ", false)
+	}
+}
+
+// ErrHostKeyChanged is returned when the key of a remote host does not match the one in a known_hosts file.
+type ErrHostKeyChanged struct {
+	Hostname string
+
+	// Fingerprints of the known and the received key
+	OldFingerprint string
+	NewFingerprint string
+
+	// File and line of the known_hosts entry containing the old key
+	File string
+	Line int
+}
+
+func (err ErrHostKeyChanged) Error() string {
+	return fmt.Sprintf("host key for %q has changed from %s to %s (known key in %s:%d)", err.Hostname, err.OldFingerprint, err.NewFingerprint, err.File, err.Line)
+}
+
+// ErrHostKeyUnknown is returned when the key of a remote host is not known and was not accepted.
+type ErrHostKeyUnknown struct {
+	Hostname    string
+	Fingerprint string
+}
+
+func (err ErrHostKeyUnknown) Error() string {
+	return fmt.Sprintf("host key %s for %q is unknown", err.Fingerprint, err.Hostname)
+}
+
+// ErrHostKeyMismatch is returned when the key of a remote host does not match the expected fingerprint.
+type ErrHostKeyMismatch struct {
+	Hostname    string
+	Expected    string
+	Fingerprint string
+}
+
+func (err ErrHostKeyMismatch) Error() string {
+	return fmt.Sprintf("host key %s for %q does not match expected fingerprint %s", err.Fingerprint, err.Hostname, err.Expected)
+}
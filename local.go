@@ -0,0 +1,35 @@
+package sshost
+
+import (
+	"os"
+	"os/user"
+)
+
+// Local holds information about the local user and machine.
+// It is used to expand '%' tokens in configuration values.
+type Local struct {
+	Username string
+	UID      string
+	Home     string
+	Hostname string
+}
+
+// CurrentLocal returns information about the current user and machine.
+func CurrentLocal() (Local, error) {
+	user, err := user.Current()
+	if err != nil {
+		return Local{}, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return Local{}, err
+	}
+
+	return Local{
+		Username: user.Username,
+		UID:      user.Uid,
+		Home:     user.HomeDir,
+		Hostname: hostname,
+	}, nil
+}
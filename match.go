@@ -0,0 +1,109 @@
+package sshost
+
+import (
+	"context"
+	"os/exec"
+
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+	"github.com/tkw1536/sshost/internal/pkg/host"
+	"github.com/tkw1536/sshost/internal/pkg/source"
+	"github.com/tkw1536/stringreader"
+)
+
+// matchSource returns the source of configuration values for h.
+//
+// It evaluates Host and Match blocks, and canonicalizes the hostname if requested.
+// When the hostname was canonicalized or the configuration requests it, makes a final pass over the configuration.
+// When the hostname was canonicalized, canonical holds the canonical hostname.
+func (env Environment) matchSource(h host.Host, ctx context.Context) (src stringreader.Source, canonical string, err error) {
+	mctx := source.Context{
+		Host:         h.Host,
+		OriginalHost: h.Host,
+		User:         h.User,
+		LocalUser:    env.Local.Username,
+		Exec: func(command string, partial source.Partial) bool {
+			return env.matchExec(command, partial, ctx)
+		},
+	}
+
+	src, final := env.Source.Match(mctx)
+
+	// resolve the first pass to determine the hostname
+	cfg, err := NewConfig(src, h, env.Defaults)
+	if err != nil {
+		return nil, "", err
+	}
+	hostname, err := expandHostname(cfg.Hostname, h.Host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	canonical, err = env.canonicalize(cfg, hostname, ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if canonical != "" {
+		hostname = canonical
+		final = true
+	}
+
+	if !final {
+		return src, "", nil
+	}
+
+	// make the final pass using the resolved hostname and user
+	mctx.Host = hostname
+	mctx.Hostname = hostname
+	mctx.User = cfg.Username
+	mctx.Final = true
+
+	last, _ := env.Source.Match(mctx)
+	return source.Layer(src, last), canonical, nil
+}
+
+var hostnameFlags = expand.Flags{
+	Tokens: "%h",
+}
+
+// expandHostname expands the tokens in a Hostname setting.
+// host is the host given by the user.
+func expandHostname(hostname string, host string) (string, error) {
+	ex := expand.Expander{
+		Context: expand.TokenContext{RemoteHost: host},
+	}
+	return ex.Expand(hostname, hostnameFlags)
+}
+
+var matchExecFlags = expand.Flags{
+	Tokens: "%CdhijkLlnpru",
+}
+
+// matchExec runs command for a 'Match exec' criterion and reports if it exited successfully.
+// When ctx is cancelled, the command is killed and the criterion does not match.
+func (env Environment) matchExec(command string, partial source.Partial, ctx context.Context) bool {
+	port := partial.Port
+	if port == "" {
+		port = "22"
+	}
+
+	ex := expand.Expander{
+		Getenv: env.getenv,
+		Context: expand.TokenContext{
+			LocalHome:     env.Local.Home,
+			RemoteHost:    partial.Hostname,
+			LocalUID:      env.Local.UID,
+			HostKeyAlias:  partial.OriginalHost,
+			LocalHostname: env.Local.Hostname,
+			OriginalHost:  partial.OriginalHost,
+			RemotePort:    port,
+			RemoteUser:    partial.User,
+			LocalUser:     env.Local.Username,
+		},
+	}
+	command, err := ex.Expand(command, matchExecFlags)
+	if err != nil {
+		return false
+	}
+
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command).Run() == nil
+}
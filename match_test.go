@@ -0,0 +1,26 @@
+package sshost
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEnvironment_NewConfigContext_exec(t *testing.T) {
+	env := newTestEnvironment(t, "Match exec \"sleep 10\"\n\tUser matched\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	cfg, err := env.NewConfigContext("example.com", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("NewConfigContext() took %v, want it to return once ctx is done", elapsed)
+	}
+	if cfg.Username == "matched" {
+		t.Errorf("NewConfigContext() matched a cancelled exec criterion")
+	}
+}
@@ -0,0 +1,127 @@
+package sshost
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const DefaultPassphrasePrompt = "Enter passphrase for key '%s': "
+
+// PassphraseCache caches passphrases of encrypted private keys, keyed by the path of the key.
+//
+// A PassphraseCache is safe for concurrent use.
+// The zero value is not usable, use NewPassphraseCache instead.
+type PassphraseCache struct {
+	m           sync.Mutex
+	passphrases map[string][]byte
+}
+
+// NewPassphraseCache creates a new empty PassphraseCache
+func NewPassphraseCache() *PassphraseCache {
+	return &PassphraseCache{
+		passphrases: make(map[string][]byte),
+	}
+}
+
+func (cache *PassphraseCache) get(path string) ([]byte, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	passphrase, ok := cache.passphrases[path]
+	return passphrase, ok
+}
+
+func (cache *PassphraseCache) set(path string, passphrase []byte) {
+	if cache == nil {
+		return
+	}
+
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	cache.passphrases[path] = passphrase
+}
+
+func (cache *PassphraseCache) forget(path string) {
+	if cache == nil {
+		return
+	}
+
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	delete(cache.passphrases, path)
+}
+
+// passphrasesM guards the creation of Environment.Passphrases by passphrases
+var passphrasesM sync.Mutex
+
+// passphrases returns the PassphraseCache of env, creating it when it is nil
+func (env *Environment) passphrases() *PassphraseCache {
+	passphrasesM.Lock()
+	defer passphrasesM.Unlock()
+
+	if env.Passphrases == nil {
+		env.Passphrases = NewPassphraseCache()
+	}
+	return env.Passphrases
+}
+
+// ErrPassphraseAttempts is returned when no correct passphrase for a private key was entered within NumberOfPasswordPrompts attempts
+var ErrPassphraseAttempts = errors.New("too many incorrect passphrases")
+
+// parsePrivateKey parses the private key read from path.
+//
+// When the key is encrypted, the passphrase is taken from the PassphraseCache of the environment.
+// Otherwise the user is prompted for it, at most NumberOfPasswordPrompts times.
+// A correct passphrase is stored in the cache.
+func (m AuthEnv) parsePrivateKey(profile *Profile, path string, pkBytes []byte) (interface{}, error) {
+	key, err := ssh.ParseRawPrivateKey(pkBytes)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return key, err
+	}
+
+	cache := profile.env.passphrases()
+	if passphrase, ok := cache.get(path); ok {
+		key, err := ssh.ParseRawPrivateKeyWithPassphrase(pkBytes, passphrase)
+		if err == nil {
+			return key, nil
+		}
+		cache.forget(path)
+	}
+
+	prompt := m.PassphrasePrompt
+	if prompt == "" {
+		prompt = DefaultPassphrasePrompt
+	}
+	prompt = strings.ReplaceAll(prompt, "%s", path)
+
+	for i := 0; i < profile.config.NumberOfPasswordPrompts; i++ {
+		m.print(prompt, false)
+		passphrase, err := m.readClosed()
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := ssh.ParseRawPrivateKeyWithPassphrase(pkBytes, []byte(passphrase))
+		if err == x509.IncorrectPasswordError {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cache.set(path, []byte(passphrase))
+		return key, nil
+	}
+	return nil, ErrPassphraseAttempts
+}
@@ -0,0 +1,69 @@
+package sshost
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthEnv_parsePrivateKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("correct"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkBytes := pem.EncodeToMemory(block)
+
+	tests := []struct {
+		name    string
+		input   string
+		noCache bool
+		wantErr error
+	}{
+		{"first attempt", "correct\n", false, nil},
+		{"second attempt", "wrong\ncorrect\n", false, nil},
+		{"third attempt", "wrong\nwrong\ncorrect\n", false, nil},
+		{"too many attempts", "wrong\nwrong\nwrong\ncorrect\n", false, ErrPassphraseAttempts},
+		{"without cache", "correct\n", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			m := AuthEnv{Stdin: strings.NewReader(tt.input), Stdout: &out}
+			profile := &Profile{
+				env:    &Environment{Auth: m, Passphrases: NewPassphraseCache()},
+				config: Config{NumberOfPasswordPrompts: 3},
+			}
+			if tt.noCache {
+				profile.env.Passphrases = nil
+			}
+
+			got, err := m.parsePrivateKey(profile, "/home/user/.ssh/id_ed25519", pkBytes)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parsePrivateKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if priv, ok := got.(*ed25519.PrivateKey); !ok || !priv.Equal(key) {
+				t.Errorf("parsePrivateKey() returned a different key")
+			}
+
+			// the passphrase is cached
+			out.Reset()
+			if _, err := m.parsePrivateKey(profile, "/home/user/.ssh/id_ed25519", pkBytes); err != nil {
+				t.Errorf("parsePrivateKey() with cached passphrase error = %v", err)
+			}
+			if out.Len() != 0 {
+				t.Errorf("parsePrivateKey() with cached passphrase prompted %q", out.String())
+			}
+		})
+	}
+}
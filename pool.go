@@ -0,0 +1,159 @@
+package sshost
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+	"golang.org/x/crypto/ssh"
+)
+
+// ClientPool shares clients for ProxyJump hosts between connections.
+//
+// Clients are keyed by the resolved configuration of the jump host, and the client used to reach it.
+// Each connection using a shared client holds a reference to it.
+// The connection to the jump host is closed once the last reference is released.
+//
+// A ClientPool is safe for concurrent use.
+// The zero value is not usable, use NewClientPool instead.
+type ClientPool struct {
+	m       sync.Mutex
+	clients map[poolKey]*pooledClient
+}
+
+// NewClientPool creates a new empty ClientPool
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		clients: make(map[poolKey]*pooledClient),
+	}
+}
+
+// Len returns the number of clients currently in the pool
+func (pool *ClientPool) Len() int {
+	pool.m.Lock()
+	defer pool.m.Unlock()
+
+	return len(pool.clients)
+}
+
+// poolKey identifies a shared client
+type poolKey struct {
+	proxy *ssh.Client
+
+	user     string
+	hostname string
+	port     uint16
+
+	proxyJump    string
+	proxyCommand string
+	fingerprint  string
+}
+
+func newPoolKey(proxy *ssh.Client, cfg Config) poolKey {
+	return poolKey{
+		proxy: proxy,
+
+		user:     cfg.Username,
+		hostname: cfg.Hostname,
+		port:     cfg.Port,
+
+		proxyJump:    strings.Join(cfg.ProxyJump, ","),
+		proxyCommand: cfg.ProxyCommand,
+		fingerprint:  cfg.HostKeyFingerprint,
+	}
+}
+
+// pooledClient is a client in the pool
+type pooledClient struct {
+	ready chan struct{} // closed once client and err are set
+
+	client *ssh.Client
+	stack  *closer.Stack
+	err    error
+
+	refs int // protected by the lock of the pool
+}
+
+// client returns a shared client for profile.
+// If no client exists yet, creates a new one, see Profile.newClient.
+//
+// The returned stack releases the reference to the client.
+func (pool *ClientPool) client(profile *Profile, proxy *ssh.Client, ctx context.Context, retry bool) (*ssh.Client, *closer.Stack, error) {
+	cfg, err := profile.GetConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	key := newPoolKey(proxy, cfg)
+
+	pool.m.Lock()
+	pc, ok := pool.clients[key]
+	if !ok {
+		pc = &pooledClient{ready: make(chan struct{})}
+		pool.clients[key] = pc
+	}
+	pc.refs++
+	pool.m.Unlock()
+
+	// create the client, or wait for it to be created
+	if !ok {
+		pc.client, pc.stack, pc.err = profile.newClient(proxy, ctx, retry)
+		if pc.err != nil {
+			// do not hand out failed clients to later callers
+			pool.remove(key, pc)
+		}
+		close(pc.ready)
+
+		if pc.err == nil {
+			go func() {
+				pc.client.Wait()
+				pool.remove(key, pc)
+			}()
+		}
+	} else {
+		select {
+		case <-pc.ready:
+		case <-ctx.Done():
+			pool.release(key, pc)
+			return nil, nil, ErrContextClosed
+		}
+	}
+
+	if pc.err != nil {
+		pool.release(key, pc)
+		return nil, nil, pc.err
+	}
+
+	var once sync.Once
+	return pc.client, closer.NewStack(closer.NewCloser(func() error {
+		once.Do(func() { pool.release(key, pc) })
+		return nil
+	})), nil
+}
+
+// release releases a reference to pc.
+// When it was the last reference, closes the client.
+func (pool *ClientPool) release(key poolKey, pc *pooledClient) {
+	pool.m.Lock()
+	pc.refs--
+	last := pc.refs == 0
+	if last && pool.clients[key] == pc {
+		delete(pool.clients, key)
+	}
+	pool.m.Unlock()
+
+	if last {
+		pc.stack.Close()
+	}
+}
+
+// remove removes pc from the pool, without closing it.
+// It is called when the connection of pc was closed.
+func (pool *ClientPool) remove(key poolKey, pc *pooledClient) {
+	pool.m.Lock()
+	defer pool.m.Unlock()
+
+	if pool.clients[key] == pc {
+		delete(pool.clients, key)
+	}
+}
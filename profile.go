@@ -3,8 +3,8 @@ package sshost
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 
@@ -21,6 +21,11 @@ type Profile struct {
 	config      Config
 	configError error
 	configValid sync.Once
+
+	// state recorded during authentication, see recordLoadedKey and recordExpiredCert
+	authM   sync.Mutex
+	loaded  []loadedKey
+	expired *ErrCertificateExpired
 }
 
 // SetConfig sets the configuration for this Profile
@@ -50,9 +55,18 @@ var ErrContextClosed = errors.New("Profile.Dial: Context was closed")
 // When the profile contains a JumpHost, this might involve connecting to other ssh hosts.
 // If the context is cancelled, the connection to any existing ssh host is closed.
 //
+// The connection to the host is attempted up to ConnectionAttempts times, waiting as specified by the Backoff of the environment in between.
+// When more than one attempt fails, the returned error is of type ErrConnectionAttempts.
+//
 // Proxy indiciates an ssh proxy to dial the connection from.
 // When proxy is nil, does not use a proxy.
 func (profile *Profile) Dial(proxy *ssh.Client, ctx context.Context) (net.Conn, *closer.Stack, error) {
+	return profile.dial(proxy, ctx, true)
+}
+
+// dial implements Dial.
+// retry indicates if the final hop should be retried according to ConnectionAttempts.
+func (profile *Profile) dial(proxy *ssh.Client, ctx context.Context, retry bool) (net.Conn, *closer.Stack, error) {
 	// shortcut: if the context is already closed, bail out immediatly!
 	if ctx.Err() != nil {
 		return nil, nil, ErrContextClosed
@@ -84,7 +98,7 @@ func (profile *Profile) Dial(proxy *ssh.Client, ctx context.Context) (net.Conn,
 	var jumpStack *closer.Stack
 	for _, jumpHost := range profile.config.ProxyJump {
 		if atomic.LoadUint32(&cancelDial) == 0 {
-			hop, jumpStack, err = profile.env.NewClient(hop, jumpHost, ctx)
+			hop, jumpStack, err = profile.env.jumpClient(hop, jumpHost, ctx)
 			stack.PushStack(jumpStack)
 		} else {
 			err = ErrContextClosed
@@ -106,50 +120,85 @@ func (profile *Profile) Dial(proxy *ssh.Client, ctx context.Context) (net.Conn,
 	if network == "" {
 		return nil, nil, ErrUnknownAddressFamily
 	}
-	address := fmt.Sprintf("%s:%d", cfg.Hostname, cfg.Port)
+	address := net.JoinHostPort(cfg.Hostname, strconv.FormatUint(uint64(cfg.Port), 10))
+
+	attempts := cfg.ConnectionAttempts
+	if !retry {
+		attempts = 1
+	}
+
+	// direct connections are made from the address given by BindAddress or BindInterface
+	var localAddr net.Addr
+	if hop == nil && cfg.ProxyCommand == "" {
+		localAddr, err = profile.localAddr(cfg, ctx)
+		if err != nil {
+			defer stack.Close()
+			return nil, nil, err
+		}
+	}
 
 	// establish the connection from the final hop to the machine itself
 	// do this either via the real network, or via the existing client
+	// when a ProxyCommand is used, it pushes the connection onto the stack itself.
 	var conn net.Conn
-	if atomic.LoadUint32(&cancelDial) == 0 {
-		if hop == nil {
-			conn, err = net.DialTimeout(network, address, cfg.ConnectTimeout)
-		} else {
-			conn, err = hop.Dial(network, address)
+	err = profile.env.retry(ctx, address, attempts, func() (err error) {
+		if atomic.LoadUint32(&cancelDial) != 0 {
+			return ErrContextClosed
 		}
-	} else {
-		err = ErrContextClosed
-	}
+
+		switch {
+		case cfg.ProxyCommand != "":
+			conn, err = profile.dialProxyCommand(cfg, stack)
+		case hop == nil:
+			dialer := net.Dialer{Timeout: cfg.ConnectTimeout, LocalAddr: localAddr}
+			conn, err = dialer.DialContext(ctx, network, address)
+		default:
+			conn, err = hop.DialContext(ctx, network, address)
+		}
+		return err
+	})
 
 	if err != nil {
 		defer stack.Close()
 		return nil, nil, err
 	}
 
-	stack.Push(conn)
+	if cfg.ProxyCommand == "" {
+		stack.Push(conn)
+	}
 	return conn, stack, nil
 }
 
-// Config creates a new ssh configuration to use for a connection
-func (profile Profile) Config() (*ssh.ClientConfig, error) {
+// Config creates a new ssh configuration to use for a connection.
+// A time limit in RekeyLimit can not be enforced, and is reported using the Warn function of the environment.
+func (profile *Profile) Config() (*ssh.ClientConfig, error) {
 	cfg, err := profile.GetConfig()
 	if err != nil {
 		return nil, err
 	}
+	if cfg.RekeyLimit.Time != 0 {
+		profile.env.warn(NewErrField(ErrRekeyTimeUnsupported, "RekeyLimit"))
+	}
+
+	hostKeyCallback, err := profile.HostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
 
 	config := &ssh.ClientConfig{
 		User: cfg.Username,
 
 		Timeout: cfg.ConnectTimeout,
 
-		// TODO: Implement security
 		HostKeyAlgorithms: cfg.HostKeyAlgorithms,
-		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback:   hostKeyCallback,
 
 		Config: ssh.Config{
 			Ciphers:      cfg.Ciphers,
 			KeyExchanges: cfg.KexAlgorithms,
 			MACs:         cfg.MACs,
+
+			RekeyThreshold: cfg.RekeyLimit.Data,
 		},
 
 		Auth: profile.env.Auth.Methods(cfg.PreferredAuthentications, profile),
@@ -159,16 +208,21 @@ func (profile Profile) Config() (*ssh.ClientConfig, error) {
 }
 
 // Connect connects to the provided host using the given connection.
-func (profile Profile) Connect(conn net.Conn) (*ssh.Client, error) {
+// Once connected, private keys loaded from an IdentityFile are added to the agent according to AddKeysToAgent.
+func (profile *Profile) Connect(conn net.Conn) (*ssh.Client, error) {
 	config, err := profile.Config()
 	if err != nil {
 		return nil, err
 	}
 
-	c, chans, reqs, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+	// the address is used to look up the host key, so use the configured name and not the remote address.
+	address := net.JoinHostPort(profile.config.Hostname, strconv.FormatUint(uint64(profile.config.Port), 10))
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
 	if err != nil {
-		return nil, err
+		return nil, profile.authFailed(err)
 	}
+	profile.env.Auth.addKeysToAgent(profile)
 
 	return ssh.NewClient(c, chans, reqs), nil
 }
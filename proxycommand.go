@@ -0,0 +1,166 @@
+package sshost
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+	"github.com/tkw1536/sshost/internal/pkg/expand"
+)
+
+var proxyCommandFlags = expand.Flags{
+	Tokens: "%hnpr",
+}
+
+// ProxyCommand returns the expanded ProxyCommand of this profile.
+// When no ProxyCommand is used, returns the empty string.
+func (profile *Profile) ProxyCommand() (string, error) {
+	command := profile.config.ProxyCommand
+	if command == "" || command == "none" {
+		return "", nil
+	}
+
+	ex := profile.expander()
+	return ex.Expand(command, proxyCommandFlags)
+}
+
+// proxyCommand returns a command that runs the ProxyCommand of this profile
+func (profile *Profile) proxyCommand() (*exec.Cmd, error) {
+	command, err := profile.ProxyCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	shell := profile.env.getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	cmd := exec.Command(shell, "-c", "exec "+command)
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
+
+// dialProxyCommand starts the ProxyCommand of this profile and returns a connection to it.
+// Any resources that need closing are pushed onto stack, even if an error occurs.
+func (profile *Profile) dialProxyCommand(cfg Config, stack *closer.Stack) (net.Conn, error) {
+	cmd, err := profile.proxyCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := commandAddr(net.JoinHostPort(cfg.Hostname, strconv.FormatUint(uint64(cfg.Port), 10)))
+	if cfg.ProxyUseFdpass {
+		return dialFdpass(cmd, addr, stack)
+	}
+	return dialCommand(cmd, addr, stack)
+}
+
+// dialCommand starts cmd and returns a connection to its standard input and output.
+// The returned connection is pushed onto stack.
+func dialCommand(cmd *exec.Cmd, addr commandAddr, stack *closer.Stack) (net.Conn, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	err = cmd.Start()
+
+	// the child ends of the pipes are no longer needed
+	stdinR.Close()
+	stdoutW.Close()
+
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, err
+	}
+
+	conn := &commandConn{
+		cmd:    cmd,
+		stdin:  stdinW,
+		stdout: stdoutR,
+		addr:   addr,
+	}
+	stack.Push(conn)
+	return conn, nil
+}
+
+// commandConn is a connection to the standard input and output of a command.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	addr   commandAddr
+
+	closeOnce sync.Once
+}
+
+func (conn *commandConn) Read(b []byte) (int, error) {
+	return conn.stdout.Read(b)
+}
+
+func (conn *commandConn) Write(b []byte) (int, error) {
+	return conn.stdin.Write(b)
+}
+
+// Close closes the connection and kills the command
+func (conn *commandConn) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.stdin.Close()
+		conn.stdout.Close()
+
+		if conn.cmd.Process != nil {
+			conn.cmd.Process.Kill()
+		}
+		conn.cmd.Wait()
+	})
+	return nil
+}
+
+func (conn *commandConn) LocalAddr() net.Addr {
+	return commandAddr("localhost:0")
+}
+
+func (conn *commandConn) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+func (conn *commandConn) SetDeadline(t time.Time) error {
+	if err := conn.stdout.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return conn.stdin.SetWriteDeadline(t)
+}
+
+func (conn *commandConn) SetReadDeadline(t time.Time) error {
+	return conn.stdout.SetReadDeadline(t)
+}
+
+func (conn *commandConn) SetWriteDeadline(t time.Time) error {
+	return conn.stdin.SetWriteDeadline(t)
+}
+
+// commandAddr is the address of a connection established by a ProxyCommand.
+// It holds the host and port the command connects to.
+type commandAddr string
+
+func (commandAddr) Network() string {
+	return "proxycommand"
+}
+
+func (addr commandAddr) String() string {
+	return string(addr)
+}
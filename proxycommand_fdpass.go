@@ -0,0 +1,100 @@
+//go:build unix
+
+package sshost
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+)
+
+var errNoFdpass = errors.New("ProxyCommand did not pass a file descriptor")
+
+// dialFdpass starts cmd and receives a connected file descriptor from it.
+// The command is connected to a unix socket pair, and is expected to pass the descriptor and exit.
+//
+// The command and the returned connection are pushed onto stack.
+func dialFdpass(cmd *exec.Cmd, addr commandAddr, stack *closer.Stack) (net.Conn, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	parent := os.NewFile(uintptr(fds[0]), "fdpass")
+	child := os.NewFile(uintptr(fds[1]), "fdpass")
+
+	cmd.Stdin = child
+	cmd.Stdout = child
+	err = cmd.Start()
+	child.Close()
+	if err != nil {
+		parent.Close()
+		return nil, err
+	}
+
+	// kill the command when the stack is closed before it passed the descriptor
+	stack.Push(closer.NewCloser(func() error {
+		parent.Close()
+		cmd.Process.Kill()
+		return nil
+	}))
+
+	fd, err := receiveFd(parent)
+	parent.Close()
+	cmd.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), "proxy")
+	defer file.Close()
+
+	conn, err := net.FileConn(file)
+	if err != nil {
+		return nil, err
+	}
+	stack.Push(conn)
+	return conn, nil
+}
+
+// receiveFd receives a single file descriptor from the unix socket file
+func receiveFd(file *os.File) (int, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	conn, err := net.FileConn(file)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	unix, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, errNoFdpass
+	}
+
+	_, oobn, _, _, err := unix.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return 0, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		fds, err := syscall.ParseUnixRights(&msg)
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		// close any additional descriptors
+		for _, extra := range fds[1:] {
+			syscall.Close(extra)
+		}
+		return fds[0], nil
+	}
+	return 0, errNoFdpass
+}
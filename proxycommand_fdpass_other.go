@@ -0,0 +1,18 @@
+//go:build !unix
+
+package sshost
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+)
+
+var errNoFdpass = errors.New("ProxyUseFdpass is not supported on this platform")
+
+// dialFdpass is not supported on this platform, and always returns an error.
+func dialFdpass(cmd *exec.Cmd, addr commandAddr, stack *closer.Stack) (net.Conn, error) {
+	return nil, errNoFdpass
+}
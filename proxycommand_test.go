@@ -0,0 +1,106 @@
+//go:build unix
+
+package sshost
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"github.com/tkw1536/sshost/internal/pkg/closer"
+)
+
+// newProxyCommandProfile returns a profile for example.com on port 2222 using command as ProxyCommand
+func newProxyCommandProfile(t *testing.T, command string) *Profile {
+	t.Helper()
+
+	config := "Port 2222\n"
+	if command != "" {
+		config += "ProxyCommand " + command + "\n"
+	}
+	return newTestProfile(t, config)
+}
+
+func TestProfile_ProxyCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"", ""},
+		{"none", ""},
+		{"nc %h %p", "nc example.com 2222"},
+		{"ssh -W %h:%p %r@jump", "ssh -W example.com:2222 user@jump"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			profile := newProxyCommandProfile(t, tt.command)
+			got, err := profile.ProxyCommand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Profile.ProxyCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfile_dialProxyCommand(t *testing.T) {
+	t.Run("echo", func(t *testing.T) {
+		profile := newProxyCommandProfile(t, "echo %h:%p")
+
+		var stack closer.Stack
+		defer stack.Close()
+
+		conn, err := profile.dialProxyCommand(profile.config, &stack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := conn.RemoteAddr().String(), "example.com:2222"; got != want {
+			t.Errorf("RemoteAddr() = %q, want %q", got, want)
+		}
+
+		got, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "example.com:2222\n"; string(got) != want {
+			t.Errorf("read %q, want %q", got, want)
+		}
+	})
+
+	t.Run("cat", func(t *testing.T) {
+		profile := newProxyCommandProfile(t, "cat")
+
+		var stack closer.Stack
+		conn, err := profile.dialProxyCommand(profile.config, &stack)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader := bufio.NewReader(conn)
+		for _, line := range []string{"hello\n", "world\n"} {
+			if _, err := io.WriteString(conn, line); err != nil {
+				t.Fatal(err)
+			}
+			got, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != line {
+				t.Errorf("read %q, want %q", got, line)
+			}
+		}
+
+		// closing the stack closes the connection and stops the command
+		if err := stack.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(conn, "closed\n"); err == nil {
+			t.Error("Write() after Close() succeeded")
+		}
+		if cmd := conn.(*commandConn).cmd; cmd.ProcessState == nil {
+			t.Error("command was not waited for")
+		}
+	})
+}
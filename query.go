@@ -0,0 +1,84 @@
+package sshost
+
+import (
+	"strings"
+
+	"github.com/tkw1536/sshost/internal/pkg/slices"
+	"golang.org/x/crypto/ssh"
+)
+
+// Query selects a set of algorithms supported by this package, see the '-Q' flag of ssh(1).
+//
+// Besides the constants below, the names of the configuration options taking algorithms
+// (such as "Ciphers" or "HostKeyAlgorithms") can be used, matched case-insensitively.
+type Query string
+
+const (
+	QueryCipher      Query = "cipher"      // ciphers
+	QueryCipherAuth  Query = "cipher-auth" // ciphers that support authenticated encryption
+	QueryCompression Query = "compression" // compression methods
+	QueryKex         Query = "kex"         // key exchange algorithms
+	QueryKey         Query = "key"         // key types, including certificates
+	QueryKeyCASign   Query = "key-ca-sign" // algorithms for signing certificates
+	QueryKeyCert     Query = "key-cert"    // certificate types
+	QueryKeyPlain    Query = "key-plain"   // key types, excluding certificates
+	QueryKeySig      Query = "key-sig"     // key types and signature algorithms, including certificates
+	QueryMAC         Query = "mac"         // message authentication codes
+	QuerySig         Query = "sig"         // signature algorithms
+)
+
+// Valid checks if the provided Query is valid
+func (q Query) Valid() bool {
+	return q.all() != nil
+}
+
+// Algorithms returns the algorithms selected by the query, in preference order.
+// When insecure is false, algorithms with known security issues are omitted.
+//
+// In case of an unknown Query, returns nil.
+// The returned slice may be modified by the caller.
+func (q Query) Algorithms(insecure bool) []string {
+	all := q.all()
+	if all == nil {
+		return nil
+	}
+
+	results := make([]string, 0, len(all))
+	for _, algo := range all {
+		if !insecure && slices.Contains(insecureAlgoNames, algo) {
+			continue
+		}
+		results = append(results, algo)
+	}
+	return results
+}
+
+// all returns all algorithms selected by the query, or nil.
+// The returned slice must not be modified.
+func (q Query) all() []string {
+	switch strings.ToLower(string(q)) {
+	case string(QueryCipher), "ciphers":
+		return knownCiperNames
+	case string(QueryCipherAuth):
+		return []string{ssh.CipherAES128GCM, ssh.CipherAES256GCM, ssh.CipherChaCha20Poly1305}
+	case string(QueryCompression):
+		return []string{"none"}
+	case string(QueryKex), "kexalgorithms":
+		return knownKexAlgos
+	case string(QueryKey):
+		return slices.Combine(knownKeyAlgos, knownCertAlgos)
+	case string(QueryKeyCASign), "casignaturealgorithms":
+		return knownSigAlgos
+	case string(QueryKeyCert):
+		return knownCertAlgos
+	case string(QueryKeyPlain):
+		return knownKeyAlgos
+	case string(QueryKeySig), "hostkeyalgorithms", "pubkeyacceptedalgorithms":
+		return sKeyAlgorithms
+	case string(QueryMAC), "macs":
+		return knownMACNames
+	case string(QuerySig):
+		return knownSigAlgos
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package sshost
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RekeyLimit specifies when the session key is renegotiated.
+type RekeyLimit struct {
+	// Data is the maximum number of bytes transmitted before the session key is renegotiated.
+	// Zero means the default of the ssh package, which depends on the cipher.
+	Data uint64
+
+	// Time is the maximum time that may pass before the session key is renegotiated.
+	// Zero means that keys are not renegotiated based on time.
+	//
+	// Time is not enforced, see ErrRekeyTimeUnsupported.
+	Time time.Duration
+}
+
+// ErrNotADataSize is returned when a data size can not be parsed
+var ErrNotADataSize = errors.New("received invalid data size")
+
+// ErrRekeyTimeUnsupported is returned by strict validation when RekeyLimit specifies a time limit.
+// Otherwise the time limit is ignored, and reported as a warning of the environment when connecting.
+//
+// The ssh package renegotiates keys only based on the amount of data transmitted,
+// and does not provide a way for clients to start a renegotiation.
+var ErrRekeyTimeUnsupported = errors.New("time-based rekeying is not supported")
+
+// parseRekeyLimit parses the value of the RekeyLimit setting.
+// It consists of a data limit, optionally followed by a time limit.
+//
+// The data limit is either "default", or a number of bytes followed by an optional 'K', 'M' or 'G' suffix.
+// The time limit is either "none", or a time in the format of parseTimeFormat.
+func parseRekeyLimit(value string) (RekeyLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return RekeyLimit{}, ErrNotADataSize
+	}
+
+	var limit RekeyLimit
+	if !strings.EqualFold(fields[0], "default") {
+		data, err := parseDataSize(fields[0])
+		if err != nil {
+			return RekeyLimit{}, err
+		}
+		limit.Data = data
+	}
+
+	if len(fields) == 2 && !strings.EqualFold(fields[1], "none") {
+		d, err := parseTimeFormat(fields[1])
+		if err != nil {
+			return RekeyLimit{}, err
+		}
+		limit.Time = d
+	}
+
+	return limit, nil
+}
+
+// parseDataSize parses a number of bytes with an optional 'K', 'M' or 'G' suffix.
+// Suffixes are case-insensitive and denote powers of 1024.
+func parseDataSize(value string) (uint64, error) {
+	var shift uint
+	switch value[len(value)-1] {
+	case 'k', 'K':
+		shift = 10
+	case 'm', 'M':
+		shift = 20
+	case 'g', 'G':
+		shift = 30
+	}
+	if shift != 0 {
+		value = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || size > (1<<64-1)>>shift {
+		return 0, ErrNotADataSize
+	}
+	return size << shift, nil
+}
@@ -0,0 +1,122 @@
+package sshost
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_parseDataSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    uint64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"1k", 1 << 10, false},
+		{"1K", 1 << 10, false},
+		{"500M", 500 << 20, false},
+		{"4g", 4 << 30, false},
+		{"18446744073709551615", 1<<64 - 1, false},
+		{"17179869183G", 17179869183 << 30, false},
+
+		{"18446744073709551616", 0, true},
+		{"17179869184G", 0, true},
+		{"K", 0, true},
+		{"1T", 0, true},
+		{"-1", 0, true},
+		{"1.5G", 0, true},
+		{"default", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseDataSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseDataSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseDataSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRekeyLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    RekeyLimit
+		wantErr bool
+	}{
+		{"default", RekeyLimit{}, false},
+		{"DEFAULT none", RekeyLimit{}, false},
+		{"1G", RekeyLimit{Data: 1 << 30}, false},
+		{"1G none", RekeyLimit{Data: 1 << 30}, false},
+		{"512M 1h", RekeyLimit{Data: 512 << 20, Time: time.Hour}, false},
+		{"default 30m", RekeyLimit{Time: 30 * time.Minute}, false},
+		{" 2K  90 ", RekeyLimit{Data: 2 << 10, Time: 90 * time.Second}, false},
+
+		{"", RekeyLimit{}, true},
+		{"none", RekeyLimit{}, true},
+		{"1G 1h extra", RekeyLimit{}, true},
+		{"1G later", RekeyLimit{}, true},
+		{"99999999999999999999", RekeyLimit{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRekeyLimit(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRekeyLimit() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseRekeyLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_rekeyLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		strict  bool
+		want    RekeyLimit
+		wantErr error
+	}{
+		{"data", "RekeyLimit 1G\n", true, RekeyLimit{Data: 1 << 30}, nil},
+		{"time strict", "RekeyLimit 1G 1h\n", true, RekeyLimit{}, ErrRekeyTimeUnsupported},
+		{"time not strict", "RekeyLimit 1G 1h\n", false, RekeyLimit{Data: 1 << 30, Time: time.Hour}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, tt.config)
+			err := cfg.Validate(tt.strict)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.RekeyLimit != tt.want {
+				t.Errorf("Validate() RekeyLimit = %v, want %v", cfg.RekeyLimit, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfile_Config_rekeyLimit(t *testing.T) {
+	profile := newTestProfile(t, "RekeyLimit 1G 1h\n")
+
+	var warnings []error
+	profile.env.Warn = func(err error) { warnings = append(warnings, err) }
+
+	config, err := profile.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RekeyThreshold != 1<<30 {
+		t.Errorf("Config() RekeyThreshold = %d, want %d", config.RekeyThreshold, 1<<30)
+	}
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrRekeyTimeUnsupported) {
+		t.Errorf("Config() warnings = %v, want ErrRekeyTimeUnsupported", warnings)
+	}
+}
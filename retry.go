@@ -0,0 +1,89 @@
+package sshost
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultBackoff is the Backoff used when an Environment does not specify one.
+// Like OpenSSH, it waits one second between connection attempts.
+func DefaultBackoff(attempt int) time.Duration {
+	return time.Second
+}
+
+// backoff returns the time to wait after the given failed attempt
+func (env Environment) backoff(attempt int) time.Duration {
+	if env.Backoff == nil {
+		return DefaultBackoff(attempt)
+	}
+	return env.Backoff(attempt)
+}
+
+// retry calls dial until it succeeds, at most attempts times.
+// Between attempts, it waits as specified by the Backoff of env.
+//
+// When dial fails more than once, returns an error of type ErrConnectionAttempts.
+// When ctx is closed, stops retrying and records ErrContextClosed as the cause.
+func (env Environment) retry(ctx context.Context, address string, attempts uint64, dial func() error) error {
+	var errs []error
+	for attempt := 1; ; attempt++ {
+		err := dial()
+		if err == nil {
+			return nil
+		}
+		if attempts <= 1 {
+			return err
+		}
+		errs = append(errs, err)
+
+		if ctx.Err() != nil {
+			return ErrConnectionAttempts{Address: address, Errors: errs, Cause: ErrContextClosed}
+		}
+		if uint64(attempt) >= attempts {
+			break
+		}
+
+		timer := time.NewTimer(env.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ErrConnectionAttempts{Address: address, Errors: errs, Cause: ErrContextClosed}
+		case <-timer.C:
+		}
+	}
+	return ErrConnectionAttempts{Address: address, Errors: errs}
+}
+
+// ErrConnectionAttempts is returned when all ConnectionAttempts to a host failed.
+// It holds the failure of every attempt, in order.
+//
+// When retrying stopped before all attempts were made, Cause holds the reason.
+type ErrConnectionAttempts struct {
+	Address string
+	Errors  []error
+	Cause   error
+}
+
+func (err ErrConnectionAttempts) Error() string {
+	messages := make([]string, len(err.Errors))
+	for i, e := range err.Errors {
+		messages[i] = fmt.Sprintf("attempt %d: %s", i+1, e)
+	}
+	if err.Cause != nil {
+		return fmt.Sprintf("connecting to %s failed: %s (%s)", err.Address, strings.Join(messages, "; "), err.Cause)
+	}
+	return fmt.Sprintf("connecting to %s failed: %s", err.Address, strings.Join(messages, "; "))
+}
+
+// Unwrap returns Cause, or the error of the last attempt when Cause is nil
+func (err ErrConnectionAttempts) Unwrap() error {
+	if err.Cause != nil {
+		return err.Cause
+	}
+	if len(err.Errors) == 0 {
+		return nil
+	}
+	return err.Errors[len(err.Errors)-1]
+}
@@ -0,0 +1,83 @@
+package sshost
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvironment_retry(t *testing.T) {
+	errDial := errors.New("dial failed")
+	env := Environment{
+		Backoff: func(attempt int) time.Duration { return time.Millisecond },
+	}
+
+	tests := []struct {
+		name      string
+		attempts  uint64
+		succeedAt int // attempt that succeeds, 0 for never
+		cancelAt  int // attempt after which the context is cancelled, 0 for never
+		wantCalls int // expected calls of dial
+		wantErr   error
+	}{
+		{"success", 3, 1, 0, 1, nil},
+		{"success after retry", 3, 3, 0, 3, nil},
+		{"single attempt", 1, 0, 0, 1, errDial},
+		{"all attempts fail", 3, 0, 0, 3, ErrConnectionAttempts{
+			Address: "example.com:22",
+			Errors:  []error{errDial, errDial, errDial},
+		}},
+		{"context closed", 3, 0, 2, 2, ErrConnectionAttempts{
+			Address: "example.com:22",
+			Errors:  []error{errDial, errDial},
+			Cause:   ErrContextClosed,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var calls int
+			err := env.retry(ctx, "example.com:22", tt.attempts, func() error {
+				calls++
+				if calls == tt.cancelAt {
+					cancel()
+				}
+				if calls == tt.succeedAt {
+					return nil
+				}
+				return errDial
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("Environment.retry() called dial %d times, want %d", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("Environment.retry() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestErrConnectionAttempts(t *testing.T) {
+	errDial := errors.New("dial failed")
+
+	err := ErrConnectionAttempts{Address: "example.com:22", Errors: []error{errDial, errDial}}
+	if got, want := err.Error(), "connecting to example.com:22 failed: attempt 1: dial failed; attempt 2: dial failed"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, errDial) {
+		t.Error("errors.Is(err, errDial) = false, want true")
+	}
+
+	err.Cause = ErrContextClosed
+	if got, want := err.Error(), "connecting to example.com:22 failed: attempt 1: dial failed; attempt 2: dial failed (Profile.Dial: Context was closed)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrContextClosed) {
+		t.Error("errors.Is(err, ErrContextClosed) = false, want true")
+	}
+}
@@ -3,28 +3,56 @@ package sshost
 
 import (
 	"os"
-	"os/user"
+	"path/filepath"
 
-	"github.com/kevinburke/ssh_config"
 	"github.com/tkw1536/sshost/internal/pkg/source"
 )
 
 // NewDefaultEnvironment creates a new environment instance from the runtime environment.
 //
-// It reads both ~/.ssh and /etc/ssh as a source, see ssh_config.DefaultUserSettings.
+// It reads both ~/.ssh/config and /etc/ssh/ssh_config as a source, see DefaultSource.
 // It uses operating system environment for defaults.
+// Passphrases of private keys are cached for the lifetime of the environment.
 func NewDefaultEnvironment() (*Environment, error) {
-	user, err := user.Current()
+	local, err := CurrentLocal()
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := DefaultSource(local.Home)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Environment{
-		Source: source.FromUserSettings(ssh_config.DefaultUserSettings),
+		Source: src,
 		Strict: true,
 		Defaults: Defaults{
-			Username: user.Username,
+			Username: local.Username,
 		},
-		Variables: os.Getenv,
+		Local:       local,
+		Passphrases: NewPassphraseCache(),
+		Variables:   os.Getenv,
 	}, nil
 }
+
+// DefaultSource returns a source that reads the user configuration file ~/.ssh/config and the system configuration file /etc/ssh/ssh_config.
+// home is the home directory of the user.
+//
+// Files that do not exist are ignored.
+// Host and Match blocks are supported, see source.FromFile.
+func DefaultSource(home string) (source.Source, error) {
+	userDir := filepath.Join(home, ".ssh")
+	user, err := source.FromFileIfExists(filepath.Join(userDir, "config"), userDir, home)
+	if err != nil {
+		return nil, err
+	}
+
+	systemDir := filepath.Join("/etc", "ssh")
+	system, err := source.FromFileIfExists(filepath.Join(systemDir, "ssh_config"), systemDir, home)
+	if err != nil {
+		return nil, err
+	}
+
+	return source.Combine(user, system), nil
+}
@@ -0,0 +1,43 @@
+package sshost
+
+// StrictHostKeyChecking specifies how unknown and changed host keys are handled.
+type StrictHostKeyChecking string
+
+const (
+	// StrictHostKeyCheckingYes never adds host keys automatically and refuses to connect to hosts with unknown or changed keys.
+	StrictHostKeyCheckingYes StrictHostKeyChecking = "yes"
+
+	// StrictHostKeyCheckingAcceptNew automatically adds unknown host keys, but refuses to connect to hosts with changed keys.
+	StrictHostKeyCheckingAcceptNew StrictHostKeyChecking = "accept-new"
+
+	// StrictHostKeyCheckingNo automatically adds unknown host keys, and connects to hosts with changed keys.
+	StrictHostKeyCheckingNo StrictHostKeyChecking = "no"
+
+	// StrictHostKeyCheckingOff is the same as StrictHostKeyCheckingNo.
+	StrictHostKeyCheckingOff StrictHostKeyChecking = "off"
+
+	// StrictHostKeyCheckingAsk asks the user before adding unknown host keys, and refuses to connect to hosts with changed keys.
+	StrictHostKeyCheckingAsk StrictHostKeyChecking = "ask"
+
+	DefaultStrictHostKeyChecking = StrictHostKeyCheckingAsk
+)
+
+// Valid checks if the provided StrictHostKeyChecking is valid
+func (s StrictHostKeyChecking) Valid() bool {
+	switch s {
+	case StrictHostKeyCheckingYes, StrictHostKeyCheckingAcceptNew, StrictHostKeyCheckingNo, StrictHostKeyCheckingOff, StrictHostKeyCheckingAsk:
+		return true
+	default:
+		return false
+	}
+}
+
+// AddsUnknown checks if unknown keys are added to the known_hosts file without asking the user.
+func (s StrictHostKeyChecking) AddsUnknown() bool {
+	return s == StrictHostKeyCheckingAcceptNew || s == StrictHostKeyCheckingNo || s == StrictHostKeyCheckingOff
+}
+
+// AllowsChanged checks if connections to hosts with changed keys may proceed.
+func (s StrictHostKeyChecking) AllowsChanged() bool {
+	return s == StrictHostKeyCheckingNo || s == StrictHostKeyCheckingOff
+}